@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/LehaAlexey/Parsing/config"
+	internalkafka "github.com/LehaAlexey/Parsing/internal/kafka"
+	"github.com/segmentio/kafka-go"
+)
+
+// dlqreplay moves messages from the dead-letter topic back to parse_requested.
+// It reads with its own consumer group, so every message is replayed once, and
+// stops after the topic has been idle for -idle.
+func main() {
+	limit := flag.Int("limit", 0, "max messages to replay, 0 means all")
+	idle := flag.Duration("idle", 10*time.Second, "stop after no new messages for this long")
+	dryRun := flag.Bool("dry-run", false, "print messages without replaying or committing them")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(os.Getenv("configPath"))
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	brokers := []string{fmt.Sprintf("%v:%v", cfg.Kafka.Host, cfg.Kafka.Port)}
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     brokers,
+		GroupID:     cfg.Kafka.GroupID + "_dlq_replay",
+		Topic:       cfg.Kafka.DeadLetterTopic,
+		StartOffset: kafka.FirstOffset,
+		MinBytes:    1,
+		MaxBytes:    10e6,
+	})
+	defer reader.Close()
+
+	writer := internalkafka.NewWriter(brokers, cfg.Kafka.ParseRequestedTopic)
+	defer writer.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	replayed := 0
+	for *limit == 0 || replayed < *limit {
+		fetchCtx, cancel := context.WithTimeout(ctx, *idle)
+		msg, err := reader.FetchMessage(fetchCtx)
+		cancel()
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+				break
+			}
+			log.Fatalf("fetch message: %v", err)
+		}
+
+		fmt.Printf("partition=%d offset=%d source=%s/%s/%s error=%q\n",
+			msg.Partition,
+			msg.Offset,
+			internalkafka.Header(msg, internalkafka.HeaderDLQSourceTopic),
+			internalkafka.Header(msg, internalkafka.HeaderDLQSourcePartition),
+			internalkafka.Header(msg, internalkafka.HeaderDLQSourceOffset),
+			internalkafka.Header(msg, internalkafka.HeaderDLQError),
+		)
+		if *dryRun {
+			replayed++
+			continue
+		}
+
+		if err := writer.WriteMessages(ctx, internalkafka.Replayed(msg)); err != nil {
+			log.Fatalf("write message: %v", err)
+		}
+		if err := reader.CommitMessages(ctx, msg); err != nil {
+			log.Fatalf("commit message: %v", err)
+		}
+		replayed++
+	}
+
+	fmt.Printf("replayed: %d\n", replayed)
+}
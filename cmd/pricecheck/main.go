@@ -2,44 +2,82 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 
+	"github.com/LehaAlexey/Parsing/config"
+	"github.com/LehaAlexey/Parsing/internal/bootstrap"
 	"github.com/LehaAlexey/Parsing/internal/parser"
 )
 
+// pricecheck fetches pages and prints what the extractor finds on them: the
+// URLs given as arguments, or a set of shops that used to fail. Host rules
+// are taken from the config in configPath, when set, and from -rules, so a
+// new rule can be tried before it is deployed.
 func main() {
-	urls := []string{
-		"https://www.pech.ru/catalog/elektroochagi/elektricheskiy-kamin-electrolux-sphere-plus-efp-p-2720rls/",
-		"https://ru.aircraft24.com/singleprop/beechcraft/55-baron-project--xi142530.htm",
-		"https://sunseeker-russia.com/yacht/sunseeker-manhattan-66-017/",
-		"https://www.dns-shop.ru/product/b30662bca87cd21a/girlanda-govee-curtain-light/",
-		"https://5ka.ru/product/nektar-global-village-ananasovyy-950ml--3634676/",
-		"https://book24.ru/product/ohota-na-ohotnika-8751063/",
-		"https://www.santehnica.ru/product/375887.html",
+	rulesFile := flag.String("rules", "", "extraction rules file, in addition to those of the config")
+	flag.Parse()
+
+	var rules []config.RuleConfig
+	if path := os.Getenv("configPath"); path != "" {
+		cfg, err := config.LoadConfig(path)
+		if err != nil {
+			log.Fatalf("failed to load config: %v", err)
+		}
+		rules = cfg.Rules
+	}
+	if *rulesFile != "" {
+		fileRules, err := config.LoadRules(*rulesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	urls := flag.Args()
+	if len(urls) == 0 {
+		urls = []string{
+			"https://www.pech.ru/catalog/elektroochagi/elektricheskiy-kamin-electrolux-sphere-plus-efp-p-2720rls/",
+			"https://ru.aircraft24.com/singleprop/beechcraft/55-baron-project--xi142530.htm",
+			"https://sunseeker-russia.com/yacht/sunseeker-manhattan-66-017/",
+			"https://www.dns-shop.ru/product/b30662bca87cd21a/girlanda-govee-curtain-light/",
+			"https://5ka.ru/product/nektar-global-village-ananasovyy-950ml--3634676/",
+			"https://book24.ru/product/ohota-na-ohotnika-8751063/",
+			"https://www.santehnica.ru/product/375887.html",
+		}
 	}
 
 	ctx := context.Background()
 	fetcher := parser.NewFetcher(parser.FetcherConfig{
 		UserAgent: "Mozilla/5.0 (Linux; Android 13; Pixel 7 Pro) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
 	})
-	extractor := parser.NewExtractor()
+	extractor, err := bootstrap.NewExtractor(rules)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	for _, url := range urls {
 		fmt.Println("URL:", url)
 
-		body, _, err := fetcher.Fetch(ctx, url)
+		fetched, err := fetcher.Fetch(ctx, url)
 		if err != nil {
 			log.Printf("error: %v\n", err)
 			continue
 		}
 
-		price, cur, ok := extractor.Extract(body)
+		// rules match the page the redirects ended on, as in the processor
+		pageURL := fetched.FinalURL
+		if pageURL == "" {
+			pageURL = url
+		}
+		res, ok := extractor.Extract(pageURL, fetched.Body)
 		if !ok {
 			fmt.Println("result: price not found")
 			continue
 		}
 
-		fmt.Printf("result: price=%d currency=%q\n\n", price, cur)
+		fmt.Printf("result: price=%s currency=%q strategy=%s rule=%q confidence=%s raw=%q\n\n", res.Amount, res.Currency, res.Strategy, res.Rule, res.Confidence, res.Raw)
 	}
 }
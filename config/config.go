@@ -3,27 +3,67 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"go.yaml.in/yaml/v4"
 )
 
 type Config struct {
-	Kafka  KafkaConfig  `yaml:"kafka"`
-	HTTP   HTTPConfig   `yaml:"http"`
-	Parser ParserConfig `yaml:"parser"`
-	Swagger SwaggerConfig `yaml:"swagger"`
+	Kafka    KafkaConfig    `yaml:"kafka"`
+	Consumer ConsumerConfig `yaml:"consumer"`
+	HTTP     HTTPConfig     `yaml:"http"`
+	Health   HealthConfig   `yaml:"health"`
+	Parser   ParserConfig   `yaml:"parser"`
+	Swagger  SwaggerConfig  `yaml:"swagger"`
+	Dedupe   DedupeConfig   `yaml:"dedupe"`
+	Publish  PublishConfig  `yaml:"publish"`
+	Rules    []RuleConfig   `yaml:"rules"`
 }
 
 type KafkaConfig struct {
-	Host                   string `yaml:"host"`
-	Port                   int    `yaml:"port"`
-	ParseRequestedTopic    string `yaml:"parse_requested_topic_name"`
-	PriceMeasuredTopic     string `yaml:"price_measured_topic_name"`
-	GroupID                string `yaml:"group_id"`
+	Host                string `yaml:"host"`
+	Port                int    `yaml:"port"`
+	ParseRequestedTopic string `yaml:"parse_requested_topic_name"`
+	PriceMeasuredTopic  string `yaml:"price_measured_topic_name"`
+	ParseFailedTopic    string `yaml:"parse_failed_topic_name"`
+	DeadLetterTopic     string `yaml:"dead_letter_topic_name"`
+	GroupID             string `yaml:"group_id"`
+}
+
+type ConsumerConfig struct {
+	MaxAttempts    int    `yaml:"max_attempts"`
+	RetryBackoffMS int    `yaml:"retry_backoff_ms"`
+	Workers        int    `yaml:"workers"`
+	MaxHostWorkers int    `yaml:"max_host_workers"`
+	QueueSize      int    `yaml:"queue_size"`
+	MaxStalenessMS int    `yaml:"max_staleness_ms"`
+	DelayStorePath string `yaml:"delay_store_path"`
+	// MaxDelayed caps the number of requests waiting for scheduled_at.
+	MaxDelayed int `yaml:"max_delayed"`
+	// MaxMemoryDelayMS is how far ahead a request may be scheduled when there
+	// is no delay store, since its offset stays uncommitted until it runs.
+	MaxMemoryDelayMS int `yaml:"max_memory_delay_ms"`
 }
 
 type HTTPConfig struct {
 	Addr string `yaml:"addr"`
+	// ParseTimeoutMS bounds a POST /v1/parse request.
+	ParseTimeoutMS int `yaml:"parse_timeout_ms"`
+	// APIToken guards /v1/parse and /admin/*, which are not served without
+	// it. PARSING_API_TOKEN overrides it.
+	APIToken string `yaml:"api_token"`
+}
+
+// HealthConfig tunes /readyz: the pod is not ready after MaxFetchFailures
+// consecutive Kafka fetch errors or MaxWriteFailures consecutive failed
+// writes to any topic.
+type HealthConfig struct {
+	CheckTimeoutMS   int `yaml:"check_timeout_ms"`
+	MaxFetchFailures int `yaml:"max_fetch_failures"`
+	MaxWriteFailures int `yaml:"max_write_failures"`
+	// RebalanceGraceMS is how long the consumer group may be rebalancing
+	// before the pod is reported not ready.
+	RebalanceGraceMS int `yaml:"rebalance_grace_ms"`
 }
 
 type ParserConfig struct {
@@ -34,6 +74,46 @@ type ParserConfig struct {
 	MinBackoffMS           int    `yaml:"min_backoff_ms"`
 	MaxBackoffMS           int    `yaml:"max_backoff_ms"`
 	PerDomainMinIntervalMS int    `yaml:"per_domain_min_interval_ms"`
+	// The per-domain interval doubles on 429/503 up to PerDomainMaxIntervalMS
+	// and shrinks by PerDomainRecoveryStepMS after each success.
+	PerDomainMaxIntervalMS  int `yaml:"per_domain_max_interval_ms"`
+	PerDomainRecoveryStepMS int `yaml:"per_domain_recovery_step_ms"`
+	MaxRetryAfterMS         int `yaml:"max_retry_after_ms"`
+	// A host's circuit opens after BreakerFailureThreshold consecutive network
+	// errors or 5xx and stays open for BreakerCoolDownMS.
+	BreakerFailureThreshold int `yaml:"breaker_failure_threshold"`
+	BreakerCoolDownMS       int `yaml:"breaker_cool_down_ms"`
+	BreakerHalfOpenRequests int `yaml:"breaker_half_open_requests"`
+	// RespectRobots enables robots.txt checks, cached for RobotsTTLMS.
+	RespectRobots bool `yaml:"respect_robots"`
+	RobotsTTLMS   int  `yaml:"robots_ttl_ms"`
+	// MaxBodyBytesByHost overrides MaxBodyBytes for a host and its subdomains.
+	// A larger body is cut and parsed anyway, or with TruncatePolicy "fail"
+	// fails the fetch.
+	MaxBodyBytesByHost map[string]int64 `yaml:"max_body_bytes_by_host"`
+	TruncatePolicy     string           `yaml:"truncate_policy"`
+
+	RulesFile string `yaml:"rules_file"`
+	// AllowPrivateNetworks lets the fetcher reach loopback, private and
+	// link-local addresses; for local development only.
+	AllowPrivateNetworks bool `yaml:"allow_private_networks"`
+}
+
+type DedupeConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Backend  string `yaml:"backend"`
+	TTLMS    int    `yaml:"ttl_ms"`
+	Capacity int    `yaml:"capacity"`
+	Path     string `yaml:"path"`
+}
+
+// PublishConfig selects when PriceMeasured is published: "always" or
+// "on_change" (only when MetaHash changed or HeartbeatMS passed).
+type PublishConfig struct {
+	Mode         string `yaml:"mode"`
+	HeartbeatMS  int    `yaml:"heartbeat_ms"`
+	StateBackend string `yaml:"state_backend"`
+	StatePath    string `yaml:"state_path"`
 }
 
 type SwaggerConfig struct {
@@ -41,6 +121,34 @@ type SwaggerConfig struct {
 	Path    string `yaml:"path"`
 }
 
+// RuleConfig describes how to extract a price from the pages of one shop.
+// A rule applies when the page host matches Host (subdomains included) or
+// the full page URL matches the URLPattern regexp.
+type RuleConfig struct {
+	Name       string          `yaml:"name"`
+	Host       string          `yaml:"host"`
+	URLPattern string          `yaml:"url_pattern"`
+	Price      RuleFieldConfig `yaml:"price"`
+	Currency   RuleFieldConfig `yaml:"currency"`
+	OldPrice   RuleFieldConfig `yaml:"old_price"`
+}
+
+// RuleFieldConfig locates a single value on the page. Selector is a CSS
+// selector; the value is taken from Attr when set, otherwise from the text
+// of the first matched element. Regex, when set, is applied to that value and
+// its first capture group (or the whole match) is used. Value is a constant
+// used when the field has no selector, e.g. a fixed currency for a shop.
+type RuleFieldConfig struct {
+	Selector string `yaml:"selector"`
+	Attr     string `yaml:"attr"`
+	Regex    string `yaml:"regex"`
+	Value    string `yaml:"value"`
+}
+
+type rulesFile struct {
+	Rules []RuleConfig `yaml:"rules"`
+}
+
 func LoadConfig(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -51,6 +159,35 @@ func LoadConfig(filename string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
 	}
+	if token := os.Getenv("PARSING_API_TOKEN"); token != "" {
+		cfg.HTTP.APIToken = token
+	}
+
+	if cfg.Parser.RulesFile != "" {
+		path := cfg.Parser.RulesFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(filename), path)
+		}
+		rules, err := LoadRules(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Rules = append(cfg.Rules, rules...)
+	}
 
 	return &cfg, nil
 }
+
+func LoadRules(filename string) ([]RuleConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var f rulesFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rules YAML: %w", err)
+	}
+
+	return f.Rules, nil
+}
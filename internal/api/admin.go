@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/LehaAlexey/Parsing/internal/parser"
+)
+
+type DomainSource interface {
+	Domains() []parser.DomainState
+}
+
+type BreakerSource interface {
+	Breakers() []parser.BreakerStatus
+}
+
+type BreakersResponse struct {
+	Breakers []parser.BreakerStatus `json:"breakers"`
+}
+
+type DomainsResponse struct {
+	Domains []parser.DomainState `json:"domains"`
+}
+
+// NewBreakersHandler serves GET /admin/breakers: the shop hosts whose circuit
+// breaker is open, half-open or has recent failures.
+func NewBreakersHandler(src BreakerSource) http.Handler {
+	return getOnly(func(w http.ResponseWriter) {
+		writeJSON(w, http.StatusOK, BreakersResponse{Breakers: src.Breakers()})
+	})
+}
+
+// NewDomainsHandler serves GET /admin/domains: the adaptive rate limit of
+// every shop host the fetcher talked to.
+func NewDomainsHandler(src DomainSource) http.Handler {
+	return getOnly(func(w http.ResponseWriter) {
+		writeJSON(w, http.StatusOK, DomainsResponse{Domains: src.Domains()})
+	})
+}
+
+func getOnly(serve func(w http.ResponseWriter)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+			return
+		}
+		serve(w)
+	})
+}
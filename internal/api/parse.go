@@ -0,0 +1,245 @@
+// Package api contains the synchronous HTTP endpoints of the service.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/LehaAlexey/Parsing/internal/models"
+	"github.com/LehaAlexey/Parsing/internal/models/events"
+	"github.com/LehaAlexey/Parsing/internal/parser"
+	"github.com/LehaAlexey/Parsing/internal/services/processors/parse_requested_processor"
+)
+
+type Processor interface {
+	Measure(ctx context.Context, req *events.ParseRequested) (*parse_requested_processor.Measurement, error)
+	Publish(ctx context.Context, m *parse_requested_processor.Measurement) (bool, error)
+}
+
+const maxRequestBytes = 64 << 10
+
+type ParseRequest struct {
+	URL       string `json:"url"`
+	ProductID string `json:"product_id,omitempty"`
+	// Publish writes the result to the PriceMeasured topic.
+	Publish bool `json:"publish,omitempty"`
+}
+
+type ParseResponse struct {
+	EventID       string        `json:"event_id"`
+	ProductID     string        `json:"product_id,omitempty"`
+	URL           string        `json:"url"`
+	FinalURL      string        `json:"final_url"`
+	Price         int64         `json:"price"`
+	PriceMinor    int64         `json:"price_minor"`
+	PriceExponent int           `json:"price_exponent"`
+	PriceDecimal  string        `json:"price_decimal"`
+	Currency      string        `json:"currency"`
+	Strategy      string        `json:"strategy"`
+	Rule          string        `json:"rule,omitempty"`
+	RawPrice      string        `json:"raw_price,omitempty"`
+	Confidence    string        `json:"confidence,omitempty"`
+	Availability  string        `json:"availability"`
+	Published     bool          `json:"published"`
+	Fetch         *FetchInfo    `json:"fetch,omitempty"`
+	Timings       TimingsMillis `json:"timings_ms"`
+	// OldPrice* and DiscountPercent are set when the page shows a price
+	// before discount.
+	OldPriceMinor   int64   `json:"old_price_minor,omitempty"`
+	OldPriceDecimal string  `json:"old_price_decimal,omitempty"`
+	DiscountPercent float64 `json:"discount_percent,omitempty"`
+	OnSale          bool    `json:"on_sale"`
+}
+
+// FetchInfo describes the shop response the price was extracted from.
+type FetchInfo struct {
+	StatusCode  int              `json:"status_code"`
+	ContentType string           `json:"content_type,omitempty"`
+	Charset     string           `json:"charset,omitempty"`
+	Redirects   []string         `json:"redirects,omitempty"`
+	Attempts    int              `json:"attempts"`
+	Truncated   bool             `json:"truncated"`
+	Timings     FetchPhaseMillis `json:"timings_ms"`
+}
+
+type FetchPhaseMillis struct {
+	Wait    int64 `json:"wait"`
+	DNS     int64 `json:"dns"`
+	Connect int64 `json:"connect"`
+	TLS     int64 `json:"tls"`
+	TTFB    int64 `json:"ttfb"`
+}
+
+type TimingsMillis struct {
+	Fetch   int64 `json:"fetch"`
+	Extract int64 `json:"extract"`
+	Publish int64 `json:"publish,omitempty"`
+	Total   int64 `json:"total"`
+}
+
+type ErrorResponse struct {
+	Error      string                 `json:"error"`
+	Category   events.FailureCategory `json:"category,omitempty"`
+	HTTPStatus int                    `json:"http_status,omitempty"`
+	Attempts   int                    `json:"attempts,omitempty"`
+}
+
+// ParseHandler serves POST /v1/parse: it runs the same fetch and extraction
+// pipeline as the Kafka consumer and returns the result.
+type ParseHandler struct {
+	processor Processor
+	timeout   time.Duration
+	// allowPrivate skips the target check, for fetchers that may reach
+	// private networks.
+	allowPrivate bool
+}
+
+func NewParseHandler(processor Processor, timeout time.Duration, allowPrivate bool) *ParseHandler {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &ParseHandler{processor: processor, timeout: timeout, allowPrivate: allowPrivate}
+}
+
+func (h *ParseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+		return
+	}
+
+	var body ParseRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid body: " + err.Error(), Category: events.FailureInvalidRequest})
+		return
+	}
+	// the fetcher checks every address it connects to; this rejects the
+	// obvious cases before anything is queued for the shop
+	if !h.allowPrivate {
+		if err := parser.CheckTarget(body.URL); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error(), Category: events.FailureInvalidRequest})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	eventID := models.NewEventID()
+	req := &events.ParseRequested{
+		EventID:       eventID,
+		OccurredAt:    time.Now().UTC(),
+		CorrelationID: eventID,
+		ProductID:     body.ProductID,
+		URL:           body.URL,
+	}
+	m, err := h.processor.Measure(ctx, req)
+	if err != nil {
+		h.writeError(w, req, err)
+		return
+	}
+
+	resp := ParseResponse{
+		EventID:       eventID,
+		ProductID:     req.ProductID,
+		URL:           req.URL,
+		FinalURL:      m.Event.SourceURL,
+		Price:         m.Event.Price,
+		PriceMinor:    m.Event.PriceMinor,
+		PriceExponent: m.Event.PriceExponent,
+		PriceDecimal:  m.Event.PriceDecimal,
+		Currency:      m.Event.Currency,
+		Strategy:      m.Event.Strategy,
+		Rule:          m.Result.Rule,
+		RawPrice:      m.Event.RawPrice,
+		Confidence:    m.Event.Confidence,
+		Availability:  m.Event.Availability,
+		Timings: TimingsMillis{
+			Fetch:   m.Timings.Fetch.Milliseconds(),
+			Extract: m.Timings.Extract.Milliseconds(),
+			Total:   m.Timings.Total.Milliseconds(),
+		},
+	}
+	if m.Event.OnSale {
+		resp.OldPriceMinor = m.Event.OldPriceMinor
+		resp.OldPriceDecimal = m.Event.OldPriceDecimal
+		resp.DiscountPercent = m.Event.DiscountPercent
+		resp.OnSale = true
+	}
+	if f := m.Fetch; f != nil {
+		resp.Fetch = &FetchInfo{
+			StatusCode:  f.StatusCode,
+			ContentType: f.ContentType,
+			Charset:     f.Charset,
+			Redirects:   f.Redirects,
+			Attempts:    f.Attempts,
+			Truncated:   f.Truncated,
+			Timings: FetchPhaseMillis{
+				Wait:    f.Timings.Wait.Milliseconds(),
+				DNS:     f.Timings.DNS.Milliseconds(),
+				Connect: f.Timings.Connect.Milliseconds(),
+				TLS:     f.Timings.TLS.Milliseconds(),
+				TTFB:    f.Timings.TTFB.Milliseconds(),
+			},
+		}
+	}
+	if body.Publish {
+		start := time.Now()
+		published, err := h.processor.Publish(ctx, m)
+		if err != nil {
+			h.writeError(w, req, err)
+			return
+		}
+		resp.Published = published
+		resp.Timings.Publish = time.Since(start).Milliseconds()
+		resp.Timings.Total += resp.Timings.Publish
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *ParseHandler) writeError(w http.ResponseWriter, req *events.ParseRequested, err error) {
+	resp := ErrorResponse{Error: err.Error()}
+	code := http.StatusInternalServerError
+
+	var f *parse_requested_processor.Failure
+	if errors.As(err, &f) {
+		resp.Category = f.Category
+		resp.HTTPStatus = f.HTTPStatus
+		resp.Attempts = f.Attempts
+		code = statusFor(f.Category)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		code = http.StatusGatewayTimeout
+	}
+
+	slog.Warn("api parse failed", "error", err.Error(), "category", resp.Category, "url", req.URL, "product_id", req.ProductID, "event_id", req.EventID)
+	writeJSON(w, code, resp)
+}
+
+func statusFor(c events.FailureCategory) int {
+	switch c {
+	case events.FailureInvalidRequest:
+		return http.StatusBadRequest
+	case events.FailureExtraction:
+		return http.StatusUnprocessableEntity
+	case events.FailureDisallowed:
+		return http.StatusForbidden
+	case events.FailurePublish:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,162 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LehaAlexey/Parsing/internal/api"
+	kafkaMocks "github.com/LehaAlexey/Parsing/internal/kafka/mocks"
+	"github.com/LehaAlexey/Parsing/internal/parser"
+	parse_requested_processor "github.com/LehaAlexey/Parsing/internal/services/processors/parse_requested_processor"
+	processorMocks "github.com/LehaAlexey/Parsing/internal/services/processors/parse_requested_processor/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHandler(t *testing.T) {
+	t.Parallel()
+
+	extractor := processorMocks.NewMockExtractor(t)
+	fetcher := processorMocks.NewMockFetcher(t)
+	fetcher.EXPECT().
+		Fetch(mock.Anything, "https://shop.example/item").
+		Return(&parser.FetchResult{
+			Body:       []byte("<html></html>"),
+			FinalURL:   "https://shop.example/item?x=1",
+			StatusCode: http.StatusOK,
+			Charset:    "windows-1251",
+			Redirects:  []string{"https://shop.example/item"},
+			Attempts:   2,
+		}, nil)
+	fetcher.EXPECT().
+		Fetch(mock.Anything, "https://shop.example/empty").
+		Return(&parser.FetchResult{Body: []byte("<html></html>"), FinalURL: ""}, nil)
+	fetcher.EXPECT().
+		Fetch(mock.Anything, "https://internal.example/item").
+		Return(nil, &parser.FetchError{Attempts: 1, Err: &parser.ForbiddenAddressError{Addr: "10.0.0.5"}})
+	extractor.EXPECT().
+		Extract("https://shop.example/item?x=1", mock.Anything).
+		Return(parser.Result{Amount: parser.Decimal{Value: 1990}, Currency: "RUB", Strategy: parser.StrategyMeta, Raw: "1 990", Confidence: parser.ConfidenceHigh}, true)
+	extractor.EXPECT().
+		Extract("https://shop.example/empty", mock.Anything).
+		Return(parser.Result{}, false)
+
+	// publish is not requested, so nothing must be written
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, kafkaMocks.NewMockWriter(t), kafkaMocks.NewMockWriter(t))
+	h := api.NewParseHandler(processor, 0, false)
+
+	t.Run("success", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/parse", strings.NewReader(`{"url":"https://shop.example/item","product_id":"p-1"}`)))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.ParseResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, "p-1", resp.ProductID)
+		require.Equal(t, "https://shop.example/item?x=1", resp.FinalURL)
+		require.Equal(t, int64(1990), resp.Price)
+		require.Equal(t, int64(199000), resp.PriceMinor)
+		require.Equal(t, "1990.00", resp.PriceDecimal)
+		require.Equal(t, "RUB", resp.Currency)
+		require.Equal(t, "meta", resp.Strategy)
+		require.False(t, resp.Published)
+		require.NotEmpty(t, resp.EventID)
+		require.NotNil(t, resp.Fetch)
+		require.Equal(t, http.StatusOK, resp.Fetch.StatusCode)
+		require.Equal(t, "windows-1251", resp.Fetch.Charset)
+		require.Equal(t, []string{"https://shop.example/item"}, resp.Fetch.Redirects)
+		require.Equal(t, 2, resp.Fetch.Attempts)
+	})
+
+	t.Run("price not found", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/parse", strings.NewReader(`{"url":"https://shop.example/empty"}`)))
+
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		var resp api.ErrorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, "extraction", string(resp.Category))
+	})
+
+	t.Run("publish", func(t *testing.T) {
+		writer := kafkaMocks.NewMockWriter(t)
+		writer.EXPECT().
+			WriteMessages(mock.Anything, mock.Anything).
+			Run(func(_ context.Context, msgs ...kafka.Message) {
+				require.Len(t, msgs, 1)
+				require.Contains(t, string(msgs[0].Value), `"product_id":"p-1"`)
+			}).
+			Return(nil).
+			Once()
+		processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, kafkaMocks.NewMockWriter(t))
+
+		rec := httptest.NewRecorder()
+		api.NewParseHandler(processor, 0, false).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/parse", strings.NewReader(`{"url":"https://shop.example/item","product_id":"p-1","publish":true}`)))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp api.ParseResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.True(t, resp.Published)
+		require.Equal(t, int64(1990), resp.Price)
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/parse", strings.NewReader(`{"link":"x"}`)))
+
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("host resolving to an internal address", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/parse", strings.NewReader(`{"url":"https://internal.example/item"}`)))
+
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		var resp api.ErrorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, "invalid_request", string(resp.Category))
+	})
+
+	for _, target := range []string{"file:///etc/passwd", "gopher://shop.example/", "http://localhost:8070/admin/domains", "http://169.254.169.254/latest/meta-data", "http://10.0.0.5/", "http://[::1]/"} {
+		t.Run("forbidden target "+target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/parse", strings.NewReader(`{"url":"`+target+`"}`)))
+
+			// rejected before the fetcher is called
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+			var resp api.ErrorResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			require.Equal(t, "invalid_request", string(resp.Category))
+		})
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	t.Parallel()
+
+	h := api.RequireToken("s3cret", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for header, want := range map[string]int{
+		"":               http.StatusUnauthorized,
+		"Bearer":         http.StatusUnauthorized,
+		"Bearer wrong":   http.StatusUnauthorized,
+		"Basic s3cret":   http.StatusUnauthorized,
+		"Bearer s3cret":  http.StatusNoContent,
+		"Bearer s3cret ": http.StatusNoContent,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/parse", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, want, rec.Code, header)
+	}
+}
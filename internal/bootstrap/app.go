@@ -2,46 +2,175 @@ package bootstrap
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/LehaAlexey/Parsing/config"
+	"github.com/LehaAlexey/Parsing/internal/api"
 	"github.com/LehaAlexey/Parsing/internal/consumer/parse_requested_consumer"
+	"github.com/LehaAlexey/Parsing/internal/dedupe"
 	"github.com/LehaAlexey/Parsing/internal/kafka"
+	"github.com/LehaAlexey/Parsing/internal/metrics"
 	"github.com/LehaAlexey/Parsing/internal/parser"
+	"github.com/LehaAlexey/Parsing/internal/pricestate"
 	"github.com/LehaAlexey/Parsing/internal/services/processors/parse_requested_processor"
+	"github.com/LehaAlexey/Parsing/internal/swagger"
 )
 
 type App struct {
 	consumer Consumer
 	server   HealthServerRunner
+	closers  []io.Closer
 }
 
 func InitApp(cfg *config.Config) (*App, error) {
 	configuration := cfg
 	brokers := []string{fmt.Sprintf("%v:%v", cfg.Kafka.Host, cfg.Kafka.Port)}
 
-	writer := kafka.NewWriter(brokers, configuration.Kafka.PriceMeasuredTopic)
-	extractor := parser.NewExtractor()
+	writer := newWriter(brokers, configuration.Kafka.PriceMeasuredTopic)
+	failedWriter := newWriter(brokers, configuration.Kafka.ParseFailedTopic)
+	dlqWriter := newWriter(brokers, configuration.Kafka.DeadLetterTopic)
+	extractor, err := NewExtractor(configuration.Rules)
+	if err != nil {
+		return nil, err
+	}
+	switch parser.TruncatePolicy(configuration.Parser.TruncatePolicy) {
+	case "", parser.TruncateFail, parser.TruncateContinue:
+	default:
+		return nil, fmt.Errorf("parser: unknown truncate policy %q", configuration.Parser.TruncatePolicy)
+	}
 	fetcher := parser.NewFetcher(parser.FetcherConfig{
-		UserAgent:              configuration.Parser.UserAgent,
-		RequestTimeout:         time.Duration(configuration.Parser.RequestTimeoutMS) * time.Millisecond,
-		MaxBodyBytes:           configuration.Parser.MaxBodyBytes,
-		Retries:                configuration.Parser.Retries,
-		MinBackoff:             time.Duration(configuration.Parser.MinBackoffMS) * time.Millisecond,
-		MaxBackoff:             time.Duration(configuration.Parser.MaxBackoffMS) * time.Millisecond,
-		PerDomainMinInterval:   time.Duration(configuration.Parser.PerDomainMinIntervalMS) * time.Millisecond,
+		UserAgent:             configuration.Parser.UserAgent,
+		RequestTimeout:        time.Duration(configuration.Parser.RequestTimeoutMS) * time.Millisecond,
+		MaxBodyBytes:          configuration.Parser.MaxBodyBytes,
+		MaxBodyBytesByHost:    configuration.Parser.MaxBodyBytesByHost,
+		TruncatePolicy:        parser.TruncatePolicy(configuration.Parser.TruncatePolicy),
+		Retries:               configuration.Parser.Retries,
+		MinBackoff:            time.Duration(configuration.Parser.MinBackoffMS) * time.Millisecond,
+		MaxBackoff:            time.Duration(configuration.Parser.MaxBackoffMS) * time.Millisecond,
+		PerDomainMinInterval:  time.Duration(configuration.Parser.PerDomainMinIntervalMS) * time.Millisecond,
+		PerDomainMaxInterval:  time.Duration(configuration.Parser.PerDomainMaxIntervalMS) * time.Millisecond,
+		PerDomainRecoveryStep: time.Duration(configuration.Parser.PerDomainRecoveryStepMS) * time.Millisecond,
+		MaxRetryAfter:         time.Duration(configuration.Parser.MaxRetryAfterMS) * time.Millisecond,
+
+		BreakerFailureThreshold: configuration.Parser.BreakerFailureThreshold,
+		BreakerCoolDown:         time.Duration(configuration.Parser.BreakerCoolDownMS) * time.Millisecond,
+		BreakerHalfOpenRequests: configuration.Parser.BreakerHalfOpenRequests,
+
+		RespectRobots: configuration.Parser.RespectRobots,
+		RobotsTTL:     time.Duration(configuration.Parser.RobotsTTLMS) * time.Millisecond,
+
+		AllowPrivateNetworks: configuration.Parser.AllowPrivateNetworks,
 	})
 
-	processor := parse_requested_processor.New(extractor, fetcher, writer)
+	closers := []io.Closer{writer, failedWriter, dlqWriter}
+
+	var opts []parse_requested_processor.Option
+	if configuration.Dedupe.Enabled {
+		store, err := newDedupeStore(configuration.Dedupe)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, store)
+		opts = append(opts, parse_requested_processor.WithDedupe(store))
+	}
+
+	switch configuration.Publish.Mode {
+	case "", "always":
+	case "on_change":
+		store, err := newPriceStateStore(configuration.Publish)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, store)
+		opts = append(opts, parse_requested_processor.WithStateStore(store))
+	default:
+		return nil, fmt.Errorf("publish: unknown mode %q", configuration.Publish.Mode)
+	}
+
+	processor := parse_requested_processor.New(parse_requested_processor.Config{
+		MaxStaleness: time.Duration(configuration.Consumer.MaxStalenessMS) * time.Millisecond,
+		Heartbeat:    time.Duration(configuration.Publish.HeartbeatMS) * time.Millisecond,
+	}, extractor, fetcher, writer, failedWriter, opts...)
+	metrics.RegisterSuppressed(processor.Suppressed)
+	clientID := consumerClientID()
 	consumer := parse_requested_consumer.New(parse_requested_consumer.Config{
-		Brokers: brokers,
-		GroupID: configuration.Kafka.GroupID,
-		Topic:   configuration.Kafka.ParseRequestedTopic,
-	}, processor)
+		Brokers:        brokers,
+		GroupID:        configuration.Kafka.GroupID,
+		ClientID:       clientID,
+		Topic:          configuration.Kafka.ParseRequestedTopic,
+		MaxAttempts:    configuration.Consumer.MaxAttempts,
+		RetryBackoff:   time.Duration(configuration.Consumer.RetryBackoffMS) * time.Millisecond,
+		Workers:        configuration.Consumer.Workers,
+		MaxHostWorkers: configuration.Consumer.MaxHostWorkers,
+		QueueSize:      configuration.Consumer.QueueSize,
+		DelayStorePath: configuration.Consumer.DelayStorePath,
+		MaxDelayed:     configuration.Consumer.MaxDelayed,
+		MaxMemoryDelay: time.Duration(configuration.Consumer.MaxMemoryDelayMS) * time.Millisecond,
+	}, processor, dlqWriter)
+
+	server := NewHealthServer(
+		configuration.HTTP.Addr,
+		time.Duration(configuration.Health.CheckTimeoutMS)*time.Millisecond,
+		readinessChecks(configuration.Health, brokers, configuration.Kafka.GroupID, clientID, consumer, writer, failedWriter, dlqWriter)...,
+	)
+	if token := configuration.HTTP.APIToken; token != "" {
+		parse := api.NewParseHandler(processor, time.Duration(configuration.HTTP.ParseTimeoutMS)*time.Millisecond, configuration.Parser.AllowPrivateNetworks)
+		server.Handle("/v1/parse", api.RequireToken(token, parse))
+		server.Handle("/admin/domains", api.RequireToken(token, api.NewDomainsHandler(fetcher)))
+		server.Handle("/admin/breakers", api.RequireToken(token, api.NewBreakersHandler(fetcher)))
+	} else {
+		slog.Warn("http.api_token is not set, /v1/parse and /admin are disabled")
+	}
+	if configuration.Swagger.Enabled {
+		path := "/" + strings.Trim(configuration.Swagger.Path, "/")
+		if path == "/" {
+			path = "/swagger"
+		}
+		docs := swagger.Handler(path)
+		server.Handle(path, docs)
+		server.Handle(path+"/", docs)
+	}
+	return &App{consumer: consumer, server: server, closers: closers}, nil
+}
+
+func (a *App) Close() error {
+	var errs []error
+	for _, c := range a.closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	server := NewHealthServer(configuration.HTTP.Addr)
-	return &App{consumer: consumer, server: server}, nil
+func newWriter(brokers []string, topic string) *kafka.MeteredWriter {
+	return kafka.NewMeteredWriter(kafka.NewWriter(brokers, topic), topic)
+}
+
+type dedupeStore interface {
+	parse_requested_processor.DedupeStore
+	io.Closer
+}
+
+func newDedupeStore(cfg config.DedupeConfig) (dedupeStore, error) {
+	ttl := time.Duration(cfg.TTLMS) * time.Millisecond
+	switch cfg.Backend {
+	case "", "memory":
+		return dedupe.NewMemory(cfg.Capacity, ttl), nil
+	case "bolt":
+		store, err := dedupe.OpenBolt(cfg.Path, ttl)
+		if err != nil {
+			return nil, fmt.Errorf("dedupe: %w", err)
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("dedupe: unknown backend %q", cfg.Backend)
+	}
 }
 
 type Consumer interface {
@@ -52,3 +181,43 @@ type HealthServerRunner interface {
 	Addr() string
 	Run(ctx context.Context) error
 }
+
+type priceStateStore interface {
+	parse_requested_processor.StateStore
+	io.Closer
+}
+
+func newPriceStateStore(cfg config.PublishConfig) (priceStateStore, error) {
+	switch cfg.StateBackend {
+	case "", "memory":
+		return pricestate.NewMemory(), nil
+	case "bolt":
+		store, err := pricestate.OpenBolt(cfg.StatePath)
+		if err != nil {
+			return nil, fmt.Errorf("publish: %w", err)
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("publish: unknown state backend %q", cfg.StateBackend)
+	}
+}
+
+// NewExtractor builds the extractor with the host rules of the config.
+func NewExtractor(ruleConfigs []config.RuleConfig) (*parser.Extractor, error) {
+	rules := make([]parser.Rule, 0, len(ruleConfigs))
+	for _, r := range ruleConfigs {
+		rules = append(rules, parser.Rule{
+			Name:       r.Name,
+			Host:       r.Host,
+			URLPattern: r.URLPattern,
+			Price:      parser.FieldRule(r.Price),
+			Currency:   parser.FieldRule(r.Currency),
+			OldPrice:   parser.FieldRule(r.OldPrice),
+		})
+	}
+	extractor, err := parser.NewExtractor(rules...)
+	if err != nil {
+		return nil, fmt.Errorf("extraction rules: %w", err)
+	}
+	return extractor, nil
+}
@@ -0,0 +1,21 @@
+package bootstrap
+
+import (
+	"testing"
+
+	"github.com/LehaAlexey/Parsing/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitApp_Twice(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Publish.Mode = "on_change"
+
+	// collectors live in the default registry: a second app in the same
+	// process must not register them again
+	for i := 0; i < 2; i++ {
+		app, err := InitApp(cfg)
+		require.NoError(t, err)
+		require.NoError(t, app.Close())
+	}
+}
@@ -0,0 +1,70 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/LehaAlexey/Parsing/config"
+	"github.com/LehaAlexey/Parsing/internal/consumer/parse_requested_consumer"
+	"github.com/LehaAlexey/Parsing/internal/kafka"
+)
+
+func readinessChecks(cfg config.HealthConfig, brokers []string, groupID, clientID string, consumer *parse_requested_consumer.ParseRequestedConsumer, writers ...*kafka.MeteredWriter) []Check {
+	maxFetchFailures := cfg.MaxFetchFailures
+	if maxFetchFailures <= 0 {
+		maxFetchFailures = 5
+	}
+	maxWriteFailures := cfg.MaxWriteFailures
+	if maxWriteFailures <= 0 {
+		maxWriteFailures = 3
+	}
+	grace := time.Duration(cfg.RebalanceGraceMS) * time.Millisecond
+	if grace <= 0 {
+		grace = time.Minute
+	}
+	group := kafka.NewGroupChecker(brokers, groupID, clientID, grace)
+
+	checks := []Check{
+		{Name: "kafka_brokers", Probe: func(ctx context.Context) error {
+			return kafka.PingBrokers(ctx, brokers)
+		}},
+		{Name: "consumer_group", Probe: func(ctx context.Context) error {
+			return group.Check(ctx)
+		}},
+		{Name: "consumer", Probe: func(context.Context) error {
+			if !consumer.Running() {
+				return errors.New("not running")
+			}
+			return tooManyFailures("fetch", maxFetchFailures)(consumer.FetchFailures())
+		}},
+	}
+	for _, w := range writers {
+		checks = append(checks, Check{Name: "writer:" + w.Topic(), Probe: func(context.Context) error {
+			return tooManyFailures("write", maxWriteFailures)(w.Failures())
+		}})
+	}
+	return checks
+}
+
+func tooManyFailures(op string, limit int) func(int, error) error {
+	return func(n int, last error) error {
+		if n < limit {
+			return nil
+		}
+		return fmt.Errorf("%d consecutive %s failures: %w", n, op, last)
+	}
+}
+
+// consumerClientID is the Kafka client ID of this process, unique among the
+// pods of the group, so the readiness check can find its own membership.
+func consumerClientID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return "parsing-" + host + "-" + strconv.Itoa(os.Getpid())
+}
@@ -33,4 +33,3 @@ func (a *App) Run(ctx context.Context) error {
 		return err
 	}
 }
-
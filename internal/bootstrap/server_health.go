@@ -2,34 +2,49 @@ package bootstrap
 
 import (
 	"context"
+	"encoding/json"
 	"net"
 	"net/http"
+	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Check is one component of the readiness probe.
+type Check struct {
+	Name  string
+	Probe func(ctx context.Context) error
+}
+
 type HealthServer struct {
-	addr string
+	addr         string
+	checks       []Check
+	checkTimeout time.Duration
+	routes       map[string]http.Handler
 }
 
-func NewHealthServer(addr string) *HealthServer {
+func NewHealthServer(addr string, checkTimeout time.Duration, checks ...Check) *HealthServer {
 	if addr == "" {
 		addr = ":8070"
 	}
-	return &HealthServer{addr: addr}
+	if checkTimeout <= 0 {
+		checkTimeout = 2 * time.Second
+	}
+	return &HealthServer{addr: addr, checks: checks, checkTimeout: checkTimeout, routes: make(map[string]http.Handler)}
 }
 
 func (s *HealthServer) Addr() string { return s.addr }
 
-func (s *HealthServer) Run(ctx context.Context) error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ok"))
-	})
+// Handle registers an additional endpoint. It must be called before Run.
+func (s *HealthServer) Handle(pattern string, h http.Handler) {
+	s.routes[pattern] = h
+}
 
+func (s *HealthServer) Run(ctx context.Context) error {
 	srv := &http.Server{
 		Addr:              s.addr,
-		Handler:           mux,
+		Handler:           s.handler(),
 		ReadHeaderTimeout: 2 * time.Second,
 	}
 
@@ -47,3 +62,66 @@ func (s *HealthServer) Run(ctx context.Context) error {
 
 	return srv.Serve(lis)
 }
+
+func (s *HealthServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	live := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+	mux.HandleFunc("/health", live)
+	mux.HandleFunc("/livez", live)
+	mux.HandleFunc("/readyz", s.ready)
+	mux.Handle("/metrics", promhttp.Handler())
+	for pattern, h := range s.routes {
+		mux.Handle(pattern, h)
+	}
+	return mux
+}
+
+type componentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type readyResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+// ready runs all checks concurrently and answers 503 if any of them fails.
+func (s *HealthServer) ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.checkTimeout)
+	defer cancel()
+
+	resp := readyResponse{Status: "ok", Components: make(map[string]componentStatus, len(s.checks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, c := range s.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st := componentStatus{Status: "ok"}
+			if err := c.Probe(ctx); err != nil {
+				st = componentStatus{Status: "fail", Error: err.Error()}
+			}
+			mu.Lock()
+			resp.Components[c.Name] = st
+			if st.Status != "ok" {
+				resp.Status = "fail"
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	if resp.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(&resp)
+}
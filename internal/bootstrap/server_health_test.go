@@ -0,0 +1,42 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthServer_Readyz(t *testing.T) {
+	t.Parallel()
+
+	ok := Check{Name: "kafka_brokers", Probe: func(context.Context) error { return nil }}
+	broken := Check{Name: "consumer", Probe: func(context.Context) error { return errors.New("not running") }}
+
+	cases := []struct {
+		name       string
+		checks     []Check
+		wantCode   int
+		wantStatus string
+	}{
+		{name: "all ok", checks: []Check{ok}, wantCode: http.StatusOK, wantStatus: "ok"},
+		{name: "one failing", checks: []Check{ok, broken}, wantCode: http.StatusServiceUnavailable, wantStatus: "fail"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			NewHealthServer("", 0, tc.checks...).handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			require.Equal(t, tc.wantCode, rec.Code)
+			var body readyResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			require.Equal(t, tc.wantStatus, body.Status)
+			require.Len(t, body.Components, len(tc.checks))
+			require.Equal(t, "ok", body.Components["kafka_brokers"].Status)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package parse_requested_consumer
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/LehaAlexey/Parsing/internal/models/events"
+	bolt "go.etcd.io/bbolt"
+)
+
+// delayQueue holds jobs scheduled in the future and pushes them into the work
+// queue when they are due.
+type delayQueue struct {
+	mu    sync.Mutex
+	items delayHeap
+	wake  chan struct{}
+}
+
+func newDelayQueue() *delayQueue {
+	return &delayQueue{wake: make(chan struct{}, 1)}
+}
+
+func (d *delayQueue) add(j *job) {
+	d.mu.Lock()
+	heap.Push(&d.items, j)
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *delayQueue) len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.items.Len()
+}
+
+// run moves due jobs to q until ctx is done or q is closed.
+func (d *delayQueue) run(ctx context.Context, q *workQueue) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		var wait time.Duration
+		for {
+			d.mu.Lock()
+			if d.items.Len() == 0 {
+				d.mu.Unlock()
+				wait = time.Hour
+				break
+			}
+			next := d.items[0]
+			wait = time.Until(next.req.ScheduledAt)
+			if wait > 0 {
+				d.mu.Unlock()
+				break
+			}
+			heap.Pop(&d.items)
+			d.mu.Unlock()
+
+			if !q.push(next) {
+				return
+			}
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+type delayHeap []*job
+
+func (h delayHeap) Len() int { return len(h) }
+
+func (h delayHeap) Less(i, j int) bool {
+	return h[i].req.ScheduledAt.Before(h[j].req.ScheduledAt)
+}
+
+func (h delayHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *delayHeap) Push(x any) { *h = append(*h, x.(*job)) }
+
+func (h *delayHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+var delayedBucket = []byte("delayed")
+
+// delayStore persists delayed requests on local disk, so their Kafka offsets
+// can be committed right away and the requests survive a restart.
+type delayStore struct {
+	db *bolt.DB
+}
+
+func openDelayStore(path string) (*delayStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create delay store dir: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open delay store: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(delayedBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("init delay store: %w", err)
+	}
+	return &delayStore{db: db}, nil
+}
+
+func (s *delayStore) save(id string, req *events.ParseRequested) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(delayedBucket).Put([]byte(id), payload)
+	})
+}
+
+func (s *delayStore) delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(delayedBucket).Delete([]byte(id))
+	})
+}
+
+func (s *delayStore) load() (map[string]events.ParseRequested, error) {
+	out := make(map[string]events.ParseRequested)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(delayedBucket).ForEach(func(k, v []byte) error {
+			var req events.ParseRequested
+			if err := json.Unmarshal(v, &req); err != nil {
+				return fmt.Errorf("delayed %s: %w", k, err)
+			}
+			out[string(k)] = req
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *delayStore) close() error {
+	return s.db.Close()
+}
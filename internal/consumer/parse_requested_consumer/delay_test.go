@@ -0,0 +1,59 @@
+package parse_requested_consumer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LehaAlexey/Parsing/internal/models/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelayQueue_ReleasesInScheduleOrder(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	d := newDelayQueue()
+	q := newWorkQueue(10, 0)
+	d.add(&job{key: "later", req: events.ParseRequested{ScheduledAt: now.Add(60 * time.Millisecond)}})
+	d.add(&job{key: "sooner", req: events.ParseRequested{ScheduledAt: now.Add(20 * time.Millisecond)}})
+
+	go d.run(ctx, q)
+
+	first := q.pop()
+	require.Equal(t, "sooner", first.key)
+	require.False(t, time.Now().Before(first.req.ScheduledAt))
+
+	second := q.pop()
+	require.Equal(t, "later", second.key)
+	require.Equal(t, 0, d.len())
+}
+
+func TestDelayStore_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "nested", "delayed.db")
+	store, err := openDelayStore(path)
+	require.NoError(t, err)
+
+	scheduledAt := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, store.save("evt-1", &events.ParseRequested{EventID: "evt-1", URL: "https://example.com", ScheduledAt: scheduledAt, Priority: 5}))
+	require.NoError(t, store.save("evt-2", &events.ParseRequested{EventID: "evt-2", URL: "https://example.com/2"}))
+	require.NoError(t, store.delete("evt-2"))
+	require.NoError(t, store.close())
+
+	store, err = openDelayStore(path)
+	require.NoError(t, err)
+	defer store.close()
+
+	stored, err := store.load()
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	require.Equal(t, "https://example.com", stored["evt-1"].URL)
+	require.Equal(t, 5, stored["evt-1"].Priority)
+	require.True(t, scheduledAt.Equal(stored["evt-1"].ScheduledAt))
+}
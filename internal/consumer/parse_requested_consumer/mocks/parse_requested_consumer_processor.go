@@ -0,0 +1,84 @@
+// Code generated by mockery v2.53.5. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	events "github.com/LehaAlexey/Parsing/internal/models/events"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// MockProcessor is an autogenerated mock type for the Processor type
+type MockProcessor struct {
+	mock.Mock
+}
+
+type MockProcessor_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *MockProcessor) EXPECT() *MockProcessor_Expecter {
+	return &MockProcessor_Expecter{mock: &_m.Mock}
+}
+
+// Handle provides a mock function with given fields: ctx, req
+func (_m *MockProcessor) Handle(ctx context.Context, req *events.ParseRequested) error {
+	ret := _m.Called(ctx, req)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Handle")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *events.ParseRequested) error); ok {
+		r0 = rf(ctx, req)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockProcessor_Handle_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Handle'
+type MockProcessor_Handle_Call struct {
+	*mock.Call
+}
+
+// Handle is a helper method to define mock.On call
+//   - ctx context.Context
+//   - req *events.ParseRequested
+func (_e *MockProcessor_Expecter) Handle(ctx interface{}, req interface{}) *MockProcessor_Handle_Call {
+	return &MockProcessor_Handle_Call{Call: _e.mock.On("Handle", ctx, req)}
+}
+
+func (_c *MockProcessor_Handle_Call) Run(run func(ctx context.Context, req *events.ParseRequested)) *MockProcessor_Handle_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(*events.ParseRequested))
+	})
+	return _c
+}
+
+func (_c *MockProcessor_Handle_Call) Return(_a0 error) *MockProcessor_Handle_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockProcessor_Handle_Call) RunAndReturn(run func(context.Context, *events.ParseRequested) error) *MockProcessor_Handle_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewMockProcessor creates a new instance of MockProcessor. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockProcessor(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockProcessor {
+	mock := &MockProcessor{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,126 @@
+package parse_requested_consumer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+type partitionOffsets struct {
+	pending []int64
+	done    map[int64]bool
+}
+
+// offsetTracker computes which offset may be committed when messages of a
+// partition finish out of order: only the highest offset below which every
+// fetched message is done.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionOffsets
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[topicPartition]*partitionOffsets)}
+}
+
+// track registers a fetched message. A message at or below an already tracked
+// offset means the reader restarted from the committed offset (rebalance), so
+// the state of that partition is reset.
+func (t *offsetTracker) track(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tp := topicPartition{msg.Topic, msg.Partition}
+	p, ok := t.partitions[tp]
+	if !ok || (len(p.pending) > 0 && msg.Offset <= p.pending[len(p.pending)-1]) {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		t.partitions[tp] = p
+	}
+	p.pending = append(p.pending, msg.Offset)
+	p.done[msg.Offset] = false
+}
+
+// done marks the message as finished and returns the message to commit, if
+// the contiguous prefix of finished messages moved forward.
+func (t *offsetTracker) done(msg kafka.Message) (kafka.Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[topicPartition{msg.Topic, msg.Partition}]
+	if !ok {
+		return kafka.Message{}, false
+	}
+	if _, ok := p.done[msg.Offset]; !ok {
+		return kafka.Message{}, false
+	}
+	p.done[msg.Offset] = true
+
+	committed := int64(-1)
+	for len(p.pending) > 0 && p.done[p.pending[0]] {
+		committed = p.pending[0]
+		delete(p.done, committed)
+		p.pending = p.pending[1:]
+	}
+	if committed < 0 {
+		return kafka.Message{}, false
+	}
+
+	return kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: committed}, true
+}
+
+// committer commits finished messages through the tracker. Commits are
+// serialized and never move a partition offset backwards.
+// offsetCommitter is the part of kafka.Reader the committer uses.
+type offsetCommitter interface {
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+type committer struct {
+	reader    offsetCommitter
+	tracker   *offsetTracker
+	mu        sync.Mutex
+	committed map[topicPartition]int64
+}
+
+func newCommitter(reader offsetCommitter) *committer {
+	return &committer{
+		reader:    reader,
+		tracker:   newOffsetTracker(),
+		committed: make(map[topicPartition]int64),
+	}
+}
+
+func (c *committer) track(msg kafka.Message) {
+	c.tracker.track(msg)
+}
+
+func (c *committer) ack(ctx context.Context, msg kafka.Message) error {
+	m, ok := c.tracker.done(msg)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tp := topicPartition{m.Topic, m.Partition}
+	if last, ok := c.committed[tp]; ok && m.Offset <= last {
+		return nil
+	}
+
+	// finished work is committed even while shutting down
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := c.reader.CommitMessages(ctx, m); err != nil {
+		return err
+	}
+	c.committed[tp] = m.Offset
+	return nil
+}
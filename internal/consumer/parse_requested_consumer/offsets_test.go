@@ -0,0 +1,59 @@
+package parse_requested_consumer
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetTracker_OutOfOrder(t *testing.T) {
+	t.Parallel()
+
+	tr := newOffsetTracker()
+	msgs := []kafka.Message{
+		{Topic: "t", Partition: 0, Offset: 10},
+		{Topic: "t", Partition: 0, Offset: 11},
+		{Topic: "t", Partition: 0, Offset: 12},
+		{Topic: "t", Partition: 1, Offset: 3},
+	}
+	for _, m := range msgs {
+		tr.track(m)
+	}
+
+	_, ok := tr.done(msgs[1])
+	require.False(t, ok)
+
+	_, ok = tr.done(msgs[2])
+	require.False(t, ok)
+
+	commit, ok := tr.done(msgs[0])
+	require.True(t, ok)
+	require.Equal(t, int64(12), commit.Offset)
+	require.Equal(t, 0, commit.Partition)
+
+	commit, ok = tr.done(msgs[3])
+	require.True(t, ok)
+	require.Equal(t, int64(3), commit.Offset)
+	require.Equal(t, 1, commit.Partition)
+}
+
+func TestOffsetTracker_Redelivery(t *testing.T) {
+	t.Parallel()
+
+	tr := newOffsetTracker()
+	stale := kafka.Message{Topic: "t", Offset: 5}
+	tr.track(stale)
+	tr.track(kafka.Message{Topic: "t", Offset: 6})
+
+	// the reader restarted from the committed offset
+	redelivered := kafka.Message{Topic: "t", Offset: 5}
+	tr.track(redelivered)
+
+	commit, ok := tr.done(redelivered)
+	require.True(t, ok)
+	require.Equal(t, int64(5), commit.Offset)
+
+	_, ok = tr.done(kafka.Message{Topic: "t", Offset: 6})
+	require.False(t, ok)
+}
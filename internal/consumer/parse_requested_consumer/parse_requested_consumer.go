@@ -4,9 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	internalkafka "github.com/LehaAlexey/Parsing/internal/kafka"
+	"github.com/LehaAlexey/Parsing/internal/metrics"
+	"github.com/LehaAlexey/Parsing/internal/models"
 	"github.com/LehaAlexey/Parsing/internal/models/events"
 	"github.com/segmentio/kafka-go"
 )
@@ -16,23 +25,63 @@ type Processor interface {
 }
 
 type Config struct {
-	Brokers []string
-	GroupID string
-	Topic   string
+	Brokers        []string
+	GroupID        string
+	ClientID       string
+	Topic          string
+	MaxAttempts    int
+	RetryBackoff   time.Duration
+	Workers        int
+	MaxHostWorkers int
+	QueueSize      int
+	// DelayStorePath enables persisting requests scheduled in the future. When
+	// empty they are kept in memory and their offsets stay uncommitted until
+	// they are processed, which holds back commits of the whole partition, so
+	// only requests due within MaxMemoryDelay are accepted.
+	DelayStorePath string
+	MaxMemoryDelay time.Duration
+	// MaxDelayed caps the delay queue. Requests over it are dead-lettered.
+	MaxDelayed int
 }
 
 type ParseRequestedConsumer struct {
 	cfg       Config
 	processor Processor
+	dlq       internalkafka.Writer
+
+	running       atomic.Bool
+	fetchFailures internalkafka.FailureCounter
 }
 
-func New(cfg Config, processor Processor) *ParseRequestedConsumer {
-	return &ParseRequestedConsumer{cfg: cfg, processor: processor}
+func New(cfg Config, processor Processor, dlq internalkafka.Writer) *ParseRequestedConsumer {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 3
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = time.Second
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxHostWorkers <= 0 {
+		cfg.MaxHostWorkers = 2
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = cfg.Workers * 4
+	}
+	if cfg.MaxDelayed <= 0 {
+		cfg.MaxDelayed = 10000
+	}
+	if cfg.MaxMemoryDelay <= 0 {
+		cfg.MaxMemoryDelay = time.Minute
+	}
+	return &ParseRequestedConsumer{cfg: cfg, processor: processor, dlq: dlq}
 }
 
 func (c *ParseRequestedConsumer) Consume(ctx context.Context) error {
 	r := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:           c.cfg.Brokers,
+		Dialer:            &kafka.Dialer{ClientID: c.cfg.ClientID, Timeout: 10 * time.Second, DualStack: true},
 		GroupID:           c.cfg.GroupID,
 		Topic:             c.cfg.Topic,
 		HeartbeatInterval: 3 * time.Second,
@@ -42,24 +91,313 @@ func (c *ParseRequestedConsumer) Consume(ctx context.Context) error {
 	})
 	defer r.Close()
 
+	c.running.Store(true)
+	defer c.running.Store(false)
+
+	s := &session{
+		commits: newCommitter(r),
+		queue:   newWorkQueue(c.cfg.QueueSize, c.cfg.MaxHostWorkers),
+		delay:   newDelayQueue(),
+	}
+	stop := context.AfterFunc(ctx, s.queue.close)
+	defer stop()
+
+	if c.cfg.DelayStorePath != "" {
+		store, err := openDelayStore(c.cfg.DelayStorePath)
+		if err != nil {
+			return err
+		}
+		defer store.close()
+		s.store = store
+
+		if err := c.restoreDelayed(s); err != nil {
+			return err
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.work(ctx, s)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.delay.run(ctx, s.queue)
+	}()
+	defer func() {
+		s.queue.close()
+		wg.Wait()
+	}()
+
 	for {
-		msg, err := r.ReadMessage(ctx)
+		msg, err := r.FetchMessage(ctx)
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				return err
 			}
-			slog.Error("parse_requested_consumer: read message", "error", err.Error())
+			c.fetchFailures.Record(err)
+			metrics.ReadErrors.WithLabelValues(c.cfg.Topic, "fetch").Inc()
+			slog.Error("parse_requested_consumer: fetch message", "error", err.Error())
+			continue
+		}
+		c.fetchFailures.Record(nil)
+		s.commits.track(msg)
+		if msg.HighWaterMark > 0 {
+			metrics.ConsumeLag.WithLabelValues(msg.Topic, strconv.Itoa(msg.Partition)).Set(float64(msg.HighWaterMark - msg.Offset - 1))
+		}
+
+		req, ack := c.decode(ctx, msg)
+		if req == nil {
+			if ack {
+				c.ack(ctx, s.commits, msg)
+			}
 			continue
 		}
 
-		var req events.ParseRequested
-		if err := json.Unmarshal(msg.Value, &req); err != nil {
-			slog.Error("parse_requested_consumer: unmarshal", "error", err.Error())
+		j := newJob(msg, *req)
+		if time.Until(req.ScheduledAt) > 0 {
+			c.schedule(ctx, s, j)
 			continue
 		}
+		if !s.queue.push(j) {
+			return ctx.Err()
+		}
+	}
+}
+
+// decode unmarshals msg. A malformed message is dead-lettered instead and
+// decode returns nil; ack then reports whether the dead-letter copy was
+// written, so the offset may be committed.
+func (c *ParseRequestedConsumer) decode(ctx context.Context, msg kafka.Message) (*events.ParseRequested, bool) {
+	var req events.ParseRequested
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		metrics.ReadErrors.WithLabelValues(c.cfg.Topic, "unmarshal").Inc()
+		slog.Error("parse_requested_consumer: unmarshal", "error", err.Error(), "partition", msg.Partition, "offset", msg.Offset)
+		return nil, c.deadLetter(ctx, msg, fmt.Errorf("unmarshal: %w", err), 0)
+	}
+	return &req, false
+}
+
+// Running reports whether Consume is running.
+func (c *ParseRequestedConsumer) Running() bool {
+	return c.running.Load()
+}
+
+// FetchFailures returns the number of consecutive failed fetches and the last
+// error.
+func (c *ParseRequestedConsumer) FetchFailures() (int, error) {
+	return c.fetchFailures.Consecutive()
+}
+
+// session is the state of one Consume call shared by the fetch loop and the
+// workers.
+type session struct {
+	commits *committer
+	queue   *workQueue
+	delay   *delayQueue
+	store   *delayStore
+}
 
-		if err := c.processor.Handle(ctx, &req); err != nil {
-			slog.Error("parse_requested_consumer: handle", "error", err.Error(), "url", req.URL, "product_id", req.ProductID, "event_id", req.EventID)
+func newJob(msg kafka.Message, req events.ParseRequested) *job {
+	return &job{
+		msg:      msg,
+		req:      req,
+		key:      jobKey(&req),
+		host:     jobHost(&req),
+		priority: req.Priority,
+	}
+}
+
+// schedule puts a request scheduled in the future into the delay queue. With a
+// delay store the request is persisted and its offset committed immediately.
+// Requests over MaxDelayed, or beyond MaxMemoryDelay without a store, are
+// dead-lettered: dlqreplay can bring them back later.
+func (c *ParseRequestedConsumer) schedule(ctx context.Context, s *session, j *job) {
+	var reject error
+	switch {
+	case s.delay.len() >= c.cfg.MaxDelayed:
+		reject = fmt.Errorf("delay queue full (%d requests)", c.cfg.MaxDelayed)
+	case s.store == nil && time.Until(j.req.ScheduledAt) > c.cfg.MaxMemoryDelay:
+		reject = fmt.Errorf("scheduled_at %s is more than %s ahead and no delay store is configured", j.req.ScheduledAt.Format(time.RFC3339), c.cfg.MaxMemoryDelay)
+	}
+	if reject != nil {
+		metrics.ReadErrors.WithLabelValues(c.cfg.Topic, "delay").Inc()
+		slog.Error("parse_requested_consumer: request not delayed", "error", reject.Error(), "event_id", j.req.EventID)
+		if c.deadLetter(ctx, j.msg, reject, 0) {
+			c.ack(ctx, s.commits, j.msg)
+		}
+		return
+	}
+
+	if s.store != nil {
+		id := delayID(j)
+		if err := s.store.save(id, &j.req); err != nil {
+			slog.Error("parse_requested_consumer: save delayed", "error", err.Error(), "event_id", j.req.EventID)
+		} else {
+			j.delayID = id
+			c.ack(ctx, s.commits, j.msg)
 		}
 	}
+	s.delay.add(j)
+	slog.Info("parse_requested_consumer: request delayed", "event_id", j.req.EventID, "scheduled_at", j.req.ScheduledAt, "persisted", j.delayID != "", "delayed", s.delay.len())
+}
+
+func (c *ParseRequestedConsumer) restoreDelayed(s *session) error {
+	stored, err := s.store.load()
+	if err != nil {
+		return fmt.Errorf("load delayed requests: %w", err)
+	}
+	for id, req := range stored {
+		payload, err := json.Marshal(&req)
+		if err != nil {
+			return fmt.Errorf("delayed %s: %w", id, err)
+		}
+		j := newJob(kafka.Message{Topic: c.cfg.Topic, Partition: -1, Offset: -1, Value: payload}, req)
+		j.delayID = id
+		s.delay.add(j)
+	}
+	if len(stored) > 0 {
+		slog.Info("parse_requested_consumer: delayed requests restored", "count", len(stored))
+	}
+	return nil
+}
+
+func (c *ParseRequestedConsumer) work(ctx context.Context, s *session) {
+	for {
+		j := s.queue.pop()
+		if j == nil {
+			return
+		}
+		metrics.WorkersInFlight.Inc()
+		if c.process(ctx, j) {
+			c.finish(ctx, s, j)
+		}
+		metrics.WorkersInFlight.Dec()
+		s.queue.done(j)
+	}
+}
+
+func (c *ParseRequestedConsumer) finish(ctx context.Context, s *session, j *job) {
+	if j.delayID == "" {
+		c.ack(ctx, s.commits, j.msg)
+		return
+	}
+	if err := s.store.delete(j.delayID); err != nil {
+		slog.Error("parse_requested_consumer: delete delayed", "error", err.Error(), "event_id", j.req.EventID)
+	}
+}
+
+// process reports whether the message is finished: PriceMeasured, ParseFailed
+// or the dead-letter copy was written. Unfinished messages are not committed
+// and will be redelivered.
+func (c *ParseRequestedConsumer) process(ctx context.Context, j *job) bool {
+	attempts, err := c.handle(ctx, &j.req)
+	if err == nil {
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	slog.Error("parse_requested_consumer: handle", "error", err.Error(), "url", j.req.URL, "product_id", j.req.ProductID, "event_id", j.req.EventID)
+	if published(err) {
+		return true
+	}
+	return c.deadLetter(ctx, j.msg, err, attempts)
+}
+
+func (c *ParseRequestedConsumer) ack(ctx context.Context, cm *committer, msg kafka.Message) {
+	if err := cm.ack(ctx, msg); err != nil {
+		slog.Error("parse_requested_consumer: commit", "error", err.Error(), "partition", msg.Partition, "offset", msg.Offset)
+	}
+}
+
+// handle runs the processor until it either succeeds or reports the failure
+// as ParseFailed itself. Failures that could not be reported (and panics) are
+// retried up to MaxAttempts.
+func (c *ParseRequestedConsumer) handle(ctx context.Context, req *events.ParseRequested) (int, error) {
+	var err error
+	for attempt := 1; attempt <= c.cfg.MaxAttempts; attempt++ {
+		err = c.safeHandle(ctx, req)
+		if err == nil || published(err) || ctx.Err() != nil {
+			return attempt, err
+		}
+		if attempt == c.cfg.MaxAttempts {
+			break
+		}
+
+		slog.Warn("parse_requested_consumer: retrying", "error", err.Error(), "attempt", attempt, "event_id", req.EventID)
+		timer := time.NewTimer(c.cfg.RetryBackoff * time.Duration(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return c.cfg.MaxAttempts, err
+}
+
+func (c *ParseRequestedConsumer) safeHandle(ctx context.Context, req *events.ParseRequested) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.processor.Handle(ctx, req)
+}
+
+// deadLetter writes msg to the dead-letter topic, retrying until it succeeds
+// or ctx is done, since the offset must not be committed before that.
+func (c *ParseRequestedConsumer) deadLetter(ctx context.Context, msg kafka.Message, cause error, attempts int) bool {
+	dl := internalkafka.DeadLetter(msg, cause, attempts)
+	for try := 1; ; try++ {
+		err := c.dlq.WriteMessages(ctx, dl)
+		if err == nil {
+			slog.Warn("parse_requested_consumer: message dead-lettered", "cause", cause.Error(), "partition", msg.Partition, "offset", msg.Offset)
+			return true
+		}
+		slog.Error("parse_requested_consumer: dead letter write", "error", err.Error(), "partition", msg.Partition, "offset", msg.Offset, "try", try)
+
+		timer := time.NewTimer(min(c.cfg.RetryBackoff*time.Duration(try), 30*time.Second))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+func delayID(j *job) string {
+	if j.req.EventID != "" {
+		return j.req.EventID
+	}
+	return models.Sha256Hex(string(j.msg.Value))
+}
+
+// jobKey groups requests that must be processed in order.
+func jobKey(req *events.ParseRequested) string {
+	if req.ProductID != "" {
+		return "product:" + req.ProductID
+	}
+	return "url:" + strings.TrimSpace(req.URL)
+}
+
+func jobHost(req *events.ParseRequested) string {
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// published reports whether the processor already published the failure as
+// ParseFailed, in which case the message is done.
+func published(err error) bool {
+	var p interface{ Published() bool }
+	return errors.As(err, &p) && p.Published()
 }
@@ -0,0 +1,242 @@
+package parse_requested_consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	consumerMocks "github.com/LehaAlexey/Parsing/internal/consumer/parse_requested_consumer/mocks"
+	internalkafka "github.com/LehaAlexey/Parsing/internal/kafka"
+	kafkaMocks "github.com/LehaAlexey/Parsing/internal/kafka/mocks"
+	"github.com/LehaAlexey/Parsing/internal/models/events"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type publishedError struct{}
+
+func (publishedError) Error() string   { return "parse failed published" }
+func (publishedError) Published() bool { return true }
+
+func testMessage(value string) kafka.Message {
+	return kafka.Message{
+		Topic:     "parse_requested",
+		Partition: 2,
+		Offset:    41,
+		Key:       []byte("product-1"),
+		Value:     []byte(value),
+		Headers:   []kafka.Header{{Key: "trace", Value: []byte("t-1")}},
+	}
+}
+
+func TestDecode_MalformedIsDeadLettered(t *testing.T) {
+	t.Parallel()
+
+	dlq := kafkaMocks.NewMockWriter(t)
+	dlq.EXPECT().
+		WriteMessages(mock.Anything, mock.Anything).
+		Run(func(_ context.Context, msgs ...kafka.Message) {
+			require.Len(t, msgs, 1)
+			require.Equal(t, []byte("product-1"), msgs[0].Key)
+			require.Equal(t, []byte("{not json"), msgs[0].Value)
+			require.Contains(t, internalkafka.Header(msgs[0], internalkafka.HeaderDLQError), "unmarshal")
+			require.Equal(t, "0", internalkafka.Header(msgs[0], internalkafka.HeaderDLQAttempts))
+			require.Equal(t, "parse_requested", internalkafka.Header(msgs[0], internalkafka.HeaderDLQSourceTopic))
+			require.Equal(t, "2", internalkafka.Header(msgs[0], internalkafka.HeaderDLQSourcePartition))
+			require.Equal(t, "41", internalkafka.Header(msgs[0], internalkafka.HeaderDLQSourceOffset))
+		}).
+		Return(nil)
+
+	c := New(Config{RetryBackoff: time.Millisecond}, consumerMocks.NewMockProcessor(t), dlq)
+
+	req, ack := c.decode(context.Background(), testMessage("{not json"))
+	require.Nil(t, req)
+	require.True(t, ack)
+
+	req, ack = c.decode(context.Background(), testMessage(`{"event_id":"evt-1","url":"https://example.com"}`))
+	require.NotNil(t, req)
+	require.False(t, ack)
+	require.Equal(t, "evt-1", req.EventID)
+}
+
+func TestProcess_RetriesAndDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	transient := errors.New("kafka write: broker down")
+	cases := []struct {
+		name      string
+		results   []func() error
+		wantCalls int
+		// wantDLQ is the dlq.error header, empty when nothing is dead-lettered
+		wantDLQ      string
+		wantAttempts string
+	}{
+		{
+			name:      "success",
+			results:   []func() error{func() error { return nil }},
+			wantCalls: 1,
+		},
+		{
+			name:      "published failure is not retried",
+			results:   []func() error{func() error { return publishedError{} }},
+			wantCalls: 1,
+		},
+		{
+			name:      "transient failure retried until success",
+			results:   []func() error{func() error { return transient }, func() error { return nil }},
+			wantCalls: 2,
+		},
+		{
+			name:         "poison message",
+			results:      []func() error{func() error { return transient }},
+			wantCalls:    3,
+			wantDLQ:      transient.Error(),
+			wantAttempts: "3",
+		},
+		{
+			name:         "panic is recovered and retried",
+			results:      []func() error{func() error { panic("nil map") }},
+			wantCalls:    3,
+			wantDLQ:      "panic: nil map",
+			wantAttempts: "3",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			processor := consumerMocks.NewMockProcessor(t)
+			calls := 0
+			processor.EXPECT().
+				Handle(mock.Anything, mock.Anything).
+				RunAndReturn(func(context.Context, *events.ParseRequested) error {
+					r := tc.results[min(calls, len(tc.results)-1)]
+					calls++
+					return r()
+				}).
+				Times(tc.wantCalls)
+
+			dlq := kafkaMocks.NewMockWriter(t)
+			if tc.wantDLQ != "" {
+				dlq.EXPECT().
+					WriteMessages(mock.Anything, mock.Anything).
+					Run(func(_ context.Context, msgs ...kafka.Message) {
+						require.Len(t, msgs, 1)
+						require.Equal(t, tc.wantDLQ, internalkafka.Header(msgs[0], internalkafka.HeaderDLQError))
+						require.Equal(t, tc.wantAttempts, internalkafka.Header(msgs[0], internalkafka.HeaderDLQAttempts))
+						require.Equal(t, "t-1", internalkafka.Header(msgs[0], "trace"))
+					}).
+					Return(nil).
+					Once()
+			}
+
+			c := New(Config{MaxAttempts: 3, RetryBackoff: time.Millisecond}, processor, dlq)
+			msg := testMessage(`{"event_id":"evt-1","url":"https://example.com"}`)
+			j := newJob(msg, events.ParseRequested{EventID: "evt-1", URL: "https://example.com"})
+
+			require.True(t, c.process(context.Background(), j))
+			require.Equal(t, tc.wantCalls, calls)
+		})
+	}
+}
+
+func TestProcess_DeadLetterWriteRetried(t *testing.T) {
+	t.Parallel()
+
+	processor := consumerMocks.NewMockProcessor(t)
+	processor.EXPECT().Handle(mock.Anything, mock.Anything).Return(errors.New("boom")).Once()
+
+	dlq := kafkaMocks.NewMockWriter(t)
+	dlq.EXPECT().WriteMessages(mock.Anything, mock.Anything).Return(errors.New("leader not available")).Twice()
+	dlq.EXPECT().WriteMessages(mock.Anything, mock.Anything).Return(nil).Once()
+
+	c := New(Config{MaxAttempts: 1, RetryBackoff: time.Millisecond}, processor, dlq)
+	j := newJob(testMessage(`{}`), events.ParseRequested{EventID: "evt-1"})
+
+	require.True(t, c.process(context.Background(), j))
+}
+
+func TestProcess_CancelledIsNotCommitted(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	processor := consumerMocks.NewMockProcessor(t)
+	processor.EXPECT().
+		Handle(mock.Anything, mock.Anything).
+		RunAndReturn(func(context.Context, *events.ParseRequested) error {
+			cancel()
+			return context.Canceled
+		}).
+		Once()
+
+	// no dead-letter write: the message is redelivered after restart
+	c := New(Config{MaxAttempts: 3, RetryBackoff: time.Millisecond}, processor, kafkaMocks.NewMockWriter(t))
+	j := newJob(testMessage(`{}`), events.ParseRequested{EventID: "evt-1"})
+
+	require.False(t, c.process(ctx, j))
+}
+
+type recordingCommitter struct {
+	committed []kafka.Message
+}
+
+func (r *recordingCommitter) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.committed = append(r.committed, msgs...)
+	return nil
+}
+
+func TestSchedule_Limits(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		in        time.Duration
+		delayed   int
+		wantDLQ   string
+		wantQueue int
+	}{
+		{name: "near future kept in memory", in: 30 * time.Second, wantQueue: 1},
+		{name: "far future without store", in: time.Hour, wantDLQ: "no delay store is configured"},
+		{name: "queue full", in: time.Second, delayed: 2, wantDLQ: "delay queue full", wantQueue: 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dlq := kafkaMocks.NewMockWriter(t)
+			if tc.wantDLQ != "" {
+				dlq.EXPECT().
+					WriteMessages(mock.Anything, mock.Anything).
+					Run(func(_ context.Context, msgs ...kafka.Message) {
+						require.Contains(t, internalkafka.Header(msgs[0], internalkafka.HeaderDLQError), tc.wantDLQ)
+					}).
+					Return(nil).
+					Once()
+			}
+
+			c := New(Config{MaxDelayed: 2, MaxMemoryDelay: time.Minute, RetryBackoff: time.Millisecond}, consumerMocks.NewMockProcessor(t), dlq)
+			reader := &recordingCommitter{}
+			s := &session{commits: newCommitter(reader), delay: newDelayQueue()}
+			for i := 0; i < tc.delayed; i++ {
+				s.delay.add(&job{req: events.ParseRequested{ScheduledAt: time.Now().Add(time.Second)}})
+			}
+
+			msg := testMessage(`{}`)
+			s.commits.track(msg)
+			c.schedule(context.Background(), s, newJob(msg, events.ParseRequested{EventID: "evt-1", ScheduledAt: time.Now().Add(tc.in)}))
+
+			require.Equal(t, tc.wantQueue, s.delay.len())
+			if tc.wantDLQ != "" {
+				require.Len(t, reader.committed, 1)
+				require.Equal(t, msg.Offset, reader.committed[0].Offset)
+			} else {
+				// kept in memory: the offset waits until the request runs
+				require.Empty(t, reader.committed)
+			}
+		})
+	}
+}
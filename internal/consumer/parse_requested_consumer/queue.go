@@ -0,0 +1,143 @@
+package parse_requested_consumer
+
+import (
+	"sync"
+
+	"github.com/LehaAlexey/Parsing/internal/models/events"
+	"github.com/segmentio/kafka-go"
+)
+
+type job struct {
+	msg      kafka.Message
+	req      events.ParseRequested
+	key      string
+	host     string
+	priority int
+	seq      uint64
+	// delayID is set for jobs restored from or saved to the delay store; such
+	// jobs are removed from the store instead of committing an offset.
+	delayID string
+}
+
+// workQueue hands jobs to workers so that jobs with the same key (product) are
+// never processed concurrently and keep their arrival order, and no more than
+// maxPerHost jobs for one host run at the same time. The rest of the workers
+// stay free for other shops while a slow host is being fetched. Among runnable
+// keys the one whose next job has the highest priority goes first.
+type workQueue struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	capacity   int
+	maxPerHost int
+
+	seq     uint64
+	size    int
+	pending map[string][]*job
+	busy    map[string]bool
+	hosts   map[string]int
+	ready   []string
+	closed  bool
+}
+
+func newWorkQueue(capacity, maxPerHost int) *workQueue {
+	q := &workQueue{
+		capacity:   capacity,
+		maxPerHost: maxPerHost,
+		pending:    make(map[string][]*job),
+		busy:       make(map[string]bool),
+		hosts:      make(map[string]int),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push blocks while the queue is full. It returns false once the queue is closed.
+func (q *workQueue) push(j *job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.size >= q.capacity && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return false
+	}
+
+	q.seq++
+	j.seq = q.seq
+	q.size++
+	q.pending[j.key] = append(q.pending[j.key], j)
+	if len(q.pending[j.key]) == 1 && !q.busy[j.key] {
+		q.ready = append(q.ready, j.key)
+	}
+	q.cond.Broadcast()
+	return true
+}
+
+// pop blocks until a runnable job is available. It returns nil once the queue
+// is closed.
+func (q *workQueue) pop() *job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.closed {
+			return nil
+		}
+		if i := q.next(); i >= 0 {
+			key := q.ready[i]
+			q.ready = append(q.ready[:i], q.ready[i+1:]...)
+
+			j := q.pending[key][0]
+			q.pending[key] = q.pending[key][1:]
+			q.busy[key] = true
+			q.hosts[j.host]++
+			q.size--
+			q.cond.Broadcast()
+			return j
+		}
+		q.cond.Wait()
+	}
+}
+
+// next returns the index in ready of the highest priority (then oldest) job
+// whose host has a free slot.
+func (q *workQueue) next() int {
+	best := -1
+	var bestJob *job
+	for i, key := range q.ready {
+		j := q.pending[key][0]
+		if q.maxPerHost > 0 && q.hosts[j.host] >= q.maxPerHost {
+			continue
+		}
+		if bestJob == nil || j.priority > bestJob.priority || (j.priority == bestJob.priority && j.seq < bestJob.seq) {
+			best, bestJob = i, j
+		}
+	}
+	return best
+}
+
+// done releases the key and host of a job returned by pop.
+func (q *workQueue) done(j *job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.busy, j.key)
+	q.hosts[j.host]--
+	if q.hosts[j.host] <= 0 {
+		delete(q.hosts, j.host)
+	}
+	if len(q.pending[j.key]) > 0 {
+		q.ready = append(q.ready, j.key)
+	} else {
+		delete(q.pending, j.key)
+	}
+	q.cond.Broadcast()
+}
+
+func (q *workQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
@@ -0,0 +1,77 @@
+package parse_requested_consumer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkQueue_KeyOrdering(t *testing.T) {
+	t.Parallel()
+
+	q := newWorkQueue(10, 0)
+	require.True(t, q.push(&job{key: "a", host: "a.example"}))
+	require.True(t, q.push(&job{key: "a", host: "a.example"}))
+	require.True(t, q.push(&job{key: "b", host: "b.example"}))
+
+	first := q.pop()
+	require.Equal(t, "a", first.key)
+	require.Equal(t, uint64(1), first.seq)
+
+	// the second "a" job must wait until the first one is done
+	second := q.pop()
+	require.Equal(t, "b", second.key)
+
+	q.done(first)
+	third := q.pop()
+	require.Equal(t, "a", third.key)
+	require.Equal(t, uint64(2), third.seq)
+}
+
+func TestWorkQueue_MaxPerHost(t *testing.T) {
+	t.Parallel()
+
+	q := newWorkQueue(10, 1)
+	require.True(t, q.push(&job{key: "1", host: "slow.example"}))
+	require.True(t, q.push(&job{key: "2", host: "slow.example"}))
+	require.True(t, q.push(&job{key: "3", host: "fast.example"}))
+
+	first := q.pop()
+	require.Equal(t, "1", first.key)
+
+	second := q.pop()
+	require.Equal(t, "3", second.key)
+
+	q.done(first)
+	third := q.pop()
+	require.Equal(t, "2", third.key)
+}
+
+func TestWorkQueue_Priority(t *testing.T) {
+	t.Parallel()
+
+	q := newWorkQueue(10, 0)
+	require.True(t, q.push(&job{key: "bulk-1"}))
+	require.True(t, q.push(&job{key: "bulk-2"}))
+	require.True(t, q.push(&job{key: "urgent", priority: 10}))
+
+	require.Equal(t, "urgent", q.pop().key)
+	require.Equal(t, "bulk-1", q.pop().key)
+	require.Equal(t, "bulk-2", q.pop().key)
+}
+
+func TestWorkQueue_Close(t *testing.T) {
+	t.Parallel()
+
+	q := newWorkQueue(1, 0)
+	require.True(t, q.push(&job{key: "a"}))
+
+	done := make(chan bool)
+	go func() {
+		done <- q.push(&job{key: "b"})
+	}()
+
+	q.close()
+	require.False(t, <-done)
+	require.Nil(t, q.pop())
+}
@@ -0,0 +1,81 @@
+package currency_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/LehaAlexey/Parsing/internal/currency"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"RUB":      "RUB",
+		"rub":      "RUB",
+		"RUR":      "RUB",
+		" руб. ":   "RUB",
+		"₽":        "RUB",
+		"р.":       "RUB",
+		"€":        "EUR",
+		"£":        "GBP",
+		"₸":        "KZT",
+		"₴":        "UAH",
+		"Br":       "BYN",
+		"сум":      "UZS",
+		"¥":        "JPY",
+		"KWD":      "KWD",
+		"XYZ":      "",
+		"":         "",
+		"рублёвый": "",
+	}
+	for in, want := range cases {
+		require.Equal(t, want, currency.Normalize(in), in)
+	}
+}
+
+func TestDetectAndPrefix(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "RUB", currency.Detect("1 990 ₽"))
+	require.Equal(t, "RUB", currency.Detect("от 1990 руб./шт"))
+	require.Equal(t, "USD", currency.Detect("US$ 15"))
+	require.Equal(t, "", currency.Detect("рубашка 1990"))
+	require.Equal(t, "", currency.Detect("1990"))
+
+	require.Equal(t, "EUR", currency.Prefix("  € incl. VAT"))
+	require.Equal(t, "", currency.Prefix("incl. VAT €"))
+}
+
+func TestLeadingPattern(t *testing.T) {
+	t.Parallel()
+
+	leading := regexp.MustCompile(`^(?:` + currency.LeadingPattern() + `)$`)
+	for _, name := range []string{"€", "$", "USD", "usd", "руб", "RUB", "евро"} {
+		require.True(t, leading.MatchString(name), name)
+	}
+	// common words are only currencies after a number
+	all := regexp.MustCompile(`^(?:` + currency.Pattern() + `)$`)
+	for _, name := range []string{"gel", "Try", "Сом", "р", "Br", "AMD"} {
+		require.False(t, leading.MatchString(name), name)
+		require.True(t, all.MatchString(name), name)
+	}
+}
+
+func TestExponent(t *testing.T) {
+	t.Parallel()
+
+	exp, ok := currency.Exponent("jpy")
+	require.True(t, ok)
+	require.Equal(t, 0, exp)
+
+	exp, ok = currency.Exponent("KWD")
+	require.True(t, ok)
+	require.Equal(t, 3, exp)
+
+	exp, ok = currency.Exponent("XYZ")
+	require.False(t, ok)
+	require.Equal(t, currency.DefaultExponent, exp)
+	require.False(t, currency.Valid("XYZ"))
+}
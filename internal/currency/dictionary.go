@@ -0,0 +1,152 @@
+package currency
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// names maps symbols, local words and lower-case codes, as shops write them
+// next to a price, to ISO 4217 codes. "¥" is read as JPY and "$" as USD,
+// the most common use of each.
+var names = map[string][]string{
+	"RUB": {"₽", "руб", "рублей", "рубля", "рубль", "р", "rub", "rur"},
+	"USD": {"$", "us$", "usd", "dollar", "dollars", "долл", "доллар", "доллара", "долларов"},
+	"EUR": {"€", "eur", "euro", "euros", "евро"},
+	"GBP": {"£", "gbp"},
+	"KZT": {"₸", "тенге", "тг", "kzt"},
+	"UAH": {"₴", "грн", "гривна", "гривны", "гривен", "uah"},
+	"BYN": {"br", "бел. руб", "byn", "byr"},
+	"UZS": {"сум", "сўм", "so'm", "uzs"},
+	"KGS": {"сом", "kgs"},
+	"JPY": {"¥", "円", "yen", "jpy"},
+	"CNY": {"元", "人民币", "yuan", "rmb", "cny"},
+	"INR": {"₹", "inr"},
+	"TRY": {"₺", "try"},
+	"PLN": {"zł", "pln"},
+	"CZK": {"kč", "czk"},
+	"GEL": {"₾", "gel"},
+	"AMD": {"֏", "amd"},
+	"AZN": {"₼", "azn"},
+	"KRW": {"₩", "krw"},
+	"ILS": {"₪", "ils"},
+	"CHF": {"chf"},
+}
+
+// ambiguous names are also common words or brands: "Try 2 months free",
+// "Shower gel 250 ml", "Сом 1 кг", "AMD 7800". In free text they are only
+// read right after a number.
+var ambiguous = map[string]bool{
+	"р":   true,
+	"br":  true,
+	"сом": true,
+	"try": true,
+	"gel": true,
+	"amd": true,
+	"ils": true,
+}
+
+// legacy maps withdrawn codes still seen in shop metadata to their
+// successors.
+var legacy = map[string]string{
+	"RUR": "RUB",
+	"BYR": "BYN",
+}
+
+var (
+	byName    = map[string]string{}
+	namesRe   string
+	leadingRe string
+	detectRe  *regexp.Regexp
+	prefixRe  *regexp.Regexp
+)
+
+func init() {
+	var all, leading []string
+	for code, words := range names {
+		for _, w := range words {
+			byName[w] = code
+			all = append(all, w)
+			if !ambiguous[w] {
+				leading = append(leading, w)
+			}
+		}
+	}
+	namesRe = `(?i:` + alternation(all) + `)`
+	leadingRe = `(?i:` + alternation(leading) + `)`
+	detectRe = regexp.MustCompile(`(?:^|[^\p{L}])(` + namesRe + `)(?:[^\p{L}]|$)`)
+	prefixRe = regexp.MustCompile(`^\s*(` + namesRe + `)(?:[^\p{L}]|$)`)
+}
+
+// alternation joins words into a regexp alternation, longest first, so "руб"
+// wins over "р" and "us$" over "$".
+func alternation(words []string) string {
+	words = append([]string(nil), words...)
+	sort.Slice(words, func(i, j int) bool {
+		if len(words[i]) != len(words[j]) {
+			return len(words[i]) > len(words[j])
+		}
+		return words[i] < words[j]
+	})
+	for i, w := range words {
+		words[i] = regexp.QuoteMeta(w)
+	}
+	return strings.Join(words, "|")
+}
+
+// Pattern is a regexp alternation matching every known currency name in any
+// case. It has no capture groups and no boundaries; a match followed by a
+// letter is part of a longer word and should be rejected by the caller. Some
+// names are also common words, so in free text a match is only a currency
+// right after a number.
+func Pattern() string {
+	return namesRe
+}
+
+// LeadingPattern is like Pattern without the names that are also common
+// words; it is safe to read before a number, as in "€ 19,99" or "USD 12".
+func LeadingPattern() string {
+	return leadingRe
+}
+
+// Normalize returns the ISO 4217 code for an ISO code (any case), a withdrawn
+// code or a known symbol or word, e.g. "RUR", "руб." or "€". It returns ""
+// when s is not a known currency.
+func Normalize(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	upper := strings.ToUpper(s)
+	if Valid(upper) {
+		return upper
+	}
+	if code, ok := legacy[upper]; ok {
+		return code
+	}
+	word := strings.TrimRight(strings.ToLower(s), ".")
+	if code, ok := byName[word]; ok {
+		return code
+	}
+	return ""
+}
+
+// Detect returns the currency of the first known name in text, e.g. "RUB"
+// for "1 990 ₽", or "" if there is none.
+func Detect(text string) string {
+	m := detectRe.FindStringSubmatch(text)
+	if m == nil {
+		return ""
+	}
+	return Normalize(m[1])
+}
+
+// Prefix returns the currency named at the start of text, spaces skipped, e.g.
+// "RUB" for " руб. за шт", or "" if text does not start with one.
+func Prefix(text string) string {
+	m := prefixRe.FindStringSubmatch(text)
+	if m == nil {
+		return ""
+	}
+	return Normalize(m[1])
+}
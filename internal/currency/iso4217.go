@@ -0,0 +1,45 @@
+// Package currency knows ISO 4217 currencies and their minor units.
+package currency
+
+import "strings"
+
+// exponents maps active ISO 4217 codes to the number of digits after the
+// decimal point of their minor unit.
+var exponents = map[string]int{}
+
+func init() {
+	for exp, codes := range map[int]string{
+		0: "BIF CLP DJF GNF ISK JPY KMF KRW PYG RWF UGX UYI VND VUV XAF XOF XPF",
+		2: "AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BMD BND BOB BOV BRL BSD BTN BWP BYN BZD " +
+			"CAD CDF CHE CHF CHW CNY COP COU CRC CUP CVE CZK DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP " +
+			"GMD GTQ GYD HKD HNL HTG HUF IDR ILS INR IRR JMD KES KGS KHR KPW KYD KZT LAK LBP LKR LRD LSL MAD MDL " +
+			"MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MXV MYR MZN NAD NGN NIO NOK NPR NZD PAB PEN PGK PHP PKR PLN " +
+			"QAR RON RSD RUB SAR SBD SCR SDG SEK SGD SHP SLE SOS SRD SSP STN SVC SYP SZL THB TJS TMT TOP TRY TTD " +
+			"TWD TZS UAH USD USN UZS VED VES WST XCD YER ZAR ZMW ZWG",
+		3: "BHD IQD JOD KWD LYD OMR TND",
+		4: "CLF UYW",
+	} {
+		for _, code := range strings.Fields(codes) {
+			exponents[code] = exp
+		}
+	}
+}
+
+// DefaultExponent is used for codes that are not in ISO 4217.
+const DefaultExponent = 2
+
+// Exponent returns the minor unit digits of an ISO 4217 code. ok is false for
+// unknown codes, with DefaultExponent returned.
+func Exponent(code string) (exp int, ok bool) {
+	exp, ok = exponents[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		return DefaultExponent, false
+	}
+	return exp, true
+}
+
+// Valid reports whether code is an active ISO 4217 code.
+func Valid(code string) bool {
+	_, ok := Exponent(code)
+	return ok
+}
@@ -0,0 +1,109 @@
+package dedupe
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var completedBucket = []byte("completed")
+
+// Bolt keeps completed keys in an embedded bbolt database, so the window
+// survives restarts. Expired keys are swept at most once per ttl.
+type Bolt struct {
+	db  *bolt.DB
+	ttl time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func OpenBolt(path string, ttl time.Duration) (*Bolt, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create dedupe dir: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open dedupe store: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(completedBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("init dedupe store: %w", err)
+	}
+	return &Bolt{db: db, ttl: ttl, lastSweep: time.Now()}, nil
+}
+
+func (b *Bolt) Seen(_ context.Context, key string) (bool, error) {
+	var markAt time.Time
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(completedBucket).Get([]byte(key))
+		if len(v) == 8 {
+			markAt = time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if markAt.IsZero() {
+		return false, nil
+	}
+	return b.ttl <= 0 || time.Since(markAt) <= b.ttl, nil
+}
+
+func (b *Bolt) Mark(_ context.Context, key string) error {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], uint64(time.Now().UnixNano()))
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(completedBucket).Put([]byte(key), v[:])
+	}); err != nil {
+		return err
+	}
+	return b.maybeSweep()
+}
+
+func (b *Bolt) maybeSweep() error {
+	if b.ttl <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	if time.Since(b.lastSweep) < b.ttl {
+		b.mu.Unlock()
+		return nil
+	}
+	b.lastSweep = time.Now()
+	b.mu.Unlock()
+
+	cutoff := uint64(time.Now().Add(-b.ttl).UnixNano())
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(completedBucket)
+		var expired [][]byte
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if len(v) != 8 || binary.BigEndian.Uint64(v) < cutoff {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
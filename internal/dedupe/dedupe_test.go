@@ -0,0 +1,58 @@
+package dedupe
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_LRUAndTTL(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	m := NewMemory(2, 50*time.Millisecond)
+
+	require.NoError(t, m.Mark(ctx, "a"))
+	require.NoError(t, m.Mark(ctx, "b"))
+	require.NoError(t, m.Mark(ctx, "c"))
+
+	seen, err := m.Seen(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, seen, "evicted by capacity")
+
+	seen, err = m.Seen(ctx, "c")
+	require.NoError(t, err)
+	require.True(t, seen)
+
+	time.Sleep(60 * time.Millisecond)
+	seen, err = m.Seen(ctx, "c")
+	require.NoError(t, err)
+	require.False(t, seen, "expired by ttl")
+}
+
+func TestBolt_SurvivesReopen(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "dedupe.db")
+
+	b, err := OpenBolt(path, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, b.Mark(ctx, "evt-1"))
+	require.NoError(t, b.Close())
+
+	b, err = OpenBolt(path, time.Hour)
+	require.NoError(t, err)
+	defer b.Close()
+
+	seen, err := b.Seen(ctx, "evt-1")
+	require.NoError(t, err)
+	require.True(t, seen)
+
+	seen, err = b.Seen(ctx, "evt-2")
+	require.NoError(t, err)
+	require.False(t, seen)
+}
@@ -0,0 +1,73 @@
+package dedupe
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	key    string
+	markAt time.Time
+}
+
+// Memory is an in-process LRU of completed keys. Keys expire after ttl and the
+// least recently marked keys are evicted once capacity is reached.
+type Memory struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func NewMemory(capacity int, ttl time.Duration) *Memory {
+	if capacity <= 0 {
+		capacity = 100_000
+	}
+	return &Memory{
+		ttl:      ttl,
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (m *Memory) Seen(_ context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[key]
+	if !ok {
+		return false, nil
+	}
+	if m.ttl > 0 && time.Since(el.Value.(*entry).markAt) > m.ttl {
+		m.ll.Remove(el)
+		delete(m.items, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *Memory) Mark(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := m.items[key]; ok {
+		el.Value.(*entry).markAt = now
+		m.ll.MoveToFront(el)
+		return nil
+	}
+
+	m.items[key] = m.ll.PushFront(&entry{key: key, markAt: now})
+	for m.ll.Len() > m.capacity {
+		oldest := m.ll.Back()
+		m.ll.Remove(oldest)
+		delete(m.items, oldest.Value.(*entry).key)
+	}
+	return nil
+}
+
+func (m *Memory) Close() error { return nil }
@@ -0,0 +1,95 @@
+package kafka_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	internalkafka "github.com/LehaAlexey/Parsing/internal/kafka"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterAndReplayed(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		headers []kafka.Header
+		// want are the headers expected back after DeadLetter and Replayed
+		want []kafka.Header
+	}{
+		{
+			name: "no headers",
+		},
+		{
+			name:    "original headers kept",
+			headers: []kafka.Header{{Key: "trace", Value: []byte("t-1")}, {Key: "source", Value: []byte("scheduler")}},
+			want:    []kafka.Header{{Key: "trace", Value: []byte("t-1")}, {Key: "source", Value: []byte("scheduler")}},
+		},
+		{
+			name: "stale dlq headers of an earlier failure replaced",
+			headers: []kafka.Header{
+				{Key: "trace", Value: []byte("t-1")},
+				{Key: internalkafka.HeaderDLQError, Value: []byte("old")},
+				{Key: internalkafka.HeaderDLQAttempts, Value: []byte("9")},
+			},
+			want: []kafka.Header{{Key: "trace", Value: []byte("t-1")}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg := kafka.Message{
+				Topic:     "parse_requested",
+				Partition: 3,
+				Offset:    1234,
+				Key:       []byte("product-1"),
+				Value:     []byte(`{"event_id":"evt-1"}`),
+				Headers:   tc.headers,
+			}
+
+			before := time.Now().UTC()
+			dl := internalkafka.DeadLetter(msg, errors.New("fetch: http status 500"), 3)
+
+			require.Empty(t, dl.Topic, "the writer sets the dead-letter topic")
+			require.Equal(t, msg.Key, dl.Key)
+			require.Equal(t, msg.Value, dl.Value)
+			require.Equal(t, "fetch: http status 500", internalkafka.Header(dl, internalkafka.HeaderDLQError))
+			require.Equal(t, "3", internalkafka.Header(dl, internalkafka.HeaderDLQAttempts))
+			require.Equal(t, "parse_requested", internalkafka.Header(dl, internalkafka.HeaderDLQSourceTopic))
+			require.Equal(t, "3", internalkafka.Header(dl, internalkafka.HeaderDLQSourcePartition))
+			require.Equal(t, "1234", internalkafka.Header(dl, internalkafka.HeaderDLQSourceOffset))
+			at, err := time.Parse(time.RFC3339Nano, internalkafka.Header(dl, internalkafka.HeaderDLQTimestamp))
+			require.NoError(t, err)
+			require.False(t, at.Before(before))
+			require.Len(t, dl.Headers, len(tc.want)+6)
+
+			replayed := internalkafka.Replayed(dl)
+			require.Empty(t, replayed.Topic)
+			require.Equal(t, msg.Key, replayed.Key)
+			require.Equal(t, msg.Value, replayed.Value)
+			if len(tc.want) == 0 {
+				require.Empty(t, replayed.Headers)
+			} else {
+				require.Equal(t, tc.want, replayed.Headers)
+			}
+		})
+	}
+}
+
+func TestHeader(t *testing.T) {
+	t.Parallel()
+
+	msg := kafka.Message{Headers: []kafka.Header{
+		{Key: "a", Value: []byte("1")},
+		{Key: "a", Value: []byte("2")},
+		{Key: "b", Value: nil},
+	}}
+
+	require.Equal(t, "1", internalkafka.Header(msg, "a"))
+	require.Equal(t, "", internalkafka.Header(msg, "b"))
+	require.Equal(t, "", internalkafka.Header(msg, "missing"))
+}
@@ -0,0 +1,123 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// PingBrokers succeeds if at least one of the brokers accepts a connection.
+func PingBrokers(ctx context.Context, brokers []string) error {
+	var errs []error
+	for _, broker := range brokers {
+		conn, err := (&kafka.Dialer{}).DialContext(ctx, "tcp", broker)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		_ = conn.Close()
+		return nil
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("no brokers configured")
+	}
+	return errors.Join(errs...)
+}
+
+// GroupChecker reports whether this process is an active member of its
+// consumer group. A rebalance is normal and involves every member at once, so
+// it is tolerated for a grace period after the group was last seen stable.
+type GroupChecker struct {
+	brokers  []string
+	groupID  string
+	clientID string
+	grace    time.Duration
+
+	mu         sync.Mutex
+	lastStable time.Time
+}
+
+// NewGroupChecker checks groupID for a member with clientID, the Kafka client
+// ID the consumer of this process uses.
+func NewGroupChecker(brokers []string, groupID, clientID string, grace time.Duration) *GroupChecker {
+	return &GroupChecker{brokers: brokers, groupID: groupID, clientID: clientID, grace: grace}
+}
+
+func (c *GroupChecker) Check(ctx context.Context) error {
+	client := &kafka.Client{Addr: kafka.TCP(c.brokers...)}
+	resp, err := client.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{GroupIDs: []string{c.groupID}})
+	if err != nil {
+		return fmt.Errorf("describe group: %w", err)
+	}
+	for _, g := range resp.Groups {
+		if g.GroupID == c.groupID {
+			return c.evaluate(g, time.Now())
+		}
+	}
+	return fmt.Errorf("group %s not found", c.groupID)
+}
+
+func (c *GroupChecker) evaluate(g kafka.DescribeGroupsResponseGroup, now time.Time) error {
+	if g.Error != nil {
+		return fmt.Errorf("describe group: %w", g.Error)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch g.GroupState {
+	case "Stable":
+		if !c.isMember(g) {
+			return fmt.Errorf("client %s is not a member of group %s", c.clientID, g.GroupID)
+		}
+		c.lastStable = now
+		return nil
+	case "PreparingRebalance", "CompletingRebalance":
+		if !c.lastStable.IsZero() && now.Sub(c.lastStable) <= c.grace {
+			return nil
+		}
+		return fmt.Errorf("group state %s", g.GroupState)
+	default:
+		return fmt.Errorf("group state %s", g.GroupState)
+	}
+}
+
+func (c *GroupChecker) isMember(g kafka.DescribeGroupsResponseGroup) bool {
+	for _, m := range g.Members {
+		if c.clientID == "" || m.ClientID == c.clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// FailureCounter counts consecutive failures of an operation and keeps the
+// last error. A success resets it.
+type FailureCounter struct {
+	mu   sync.Mutex
+	n    int
+	last error
+}
+
+func (c *FailureCounter) Record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.n, c.last = 0, nil
+		return
+	}
+	c.n++
+	c.last = err
+}
+
+// Consecutive returns the number of failures since the last success and the
+// last error.
+func (c *FailureCounter) Consecutive() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n, c.last
+}
@@ -0,0 +1,63 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupChecker_Evaluate(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	member := []kafka.DescribeGroupsResponseMember{{MemberID: "m-1", ClientID: "parsing-pod-a-1"}}
+	other := []kafka.DescribeGroupsResponseMember{{MemberID: "m-2", ClientID: "parsing-pod-b-1"}}
+
+	cases := []struct {
+		name       string
+		lastStable time.Time
+		group      kafka.DescribeGroupsResponseGroup
+		wantErr    string
+	}{
+		{name: "stable member", group: kafka.DescribeGroupsResponseGroup{GroupState: "Stable", Members: member}},
+		{name: "stable without this pod", group: kafka.DescribeGroupsResponseGroup{GroupState: "Stable", Members: other}, wantErr: "not a member"},
+		{name: "empty group", group: kafka.DescribeGroupsResponseGroup{GroupState: "Empty"}, wantErr: "group state Empty"},
+		{
+			name:       "rebalance within grace",
+			lastStable: now.Add(-10 * time.Second),
+			group:      kafka.DescribeGroupsResponseGroup{GroupState: "PreparingRebalance", Members: other},
+		},
+		{
+			name:       "rebalance over grace",
+			lastStable: now.Add(-2 * time.Minute),
+			group:      kafka.DescribeGroupsResponseGroup{GroupState: "CompletingRebalance"},
+			wantErr:    "group state CompletingRebalance",
+		},
+		{
+			name:    "rebalance before first stable",
+			group:   kafka.DescribeGroupsResponseGroup{GroupState: "PreparingRebalance"},
+			wantErr: "group state PreparingRebalance",
+		},
+		{name: "describe error", group: kafka.DescribeGroupsResponseGroup{Error: errors.New("coordinator not available")}, wantErr: "coordinator not available"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := NewGroupChecker(nil, "g", "parsing-pod-a-1", time.Minute)
+			c.lastStable = tc.lastStable
+			tc.group.GroupID = "g"
+
+			err := c.evaluate(tc.group, now)
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/LehaAlexey/Parsing/internal/metrics"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -23,3 +24,36 @@ func NewWriter(brokers []string, topic string) *kafka.Writer {
 		ReadTimeout:            10 * time.Second,
 	}
 }
+
+// MeteredWriter counts written messages and write errors of a topic and
+// remembers consecutive write failures for the readiness probe.
+type MeteredWriter struct {
+	Writer
+	topic    string
+	failures FailureCounter
+}
+
+func NewMeteredWriter(w Writer, topic string) *MeteredWriter {
+	return &MeteredWriter{Writer: w, topic: topic}
+}
+
+func (w *MeteredWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	err := w.Writer.WriteMessages(ctx, msgs...)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	w.failures.Record(err)
+	if err != nil {
+		metrics.PublishErrors.WithLabelValues(w.topic).Inc()
+		return err
+	}
+	metrics.PublishedMessages.WithLabelValues(w.topic).Add(float64(len(msgs)))
+	return nil
+}
+
+func (w *MeteredWriter) Topic() string { return w.topic }
+
+// Failures returns the number of consecutive failed writes and the last error.
+func (w *MeteredWriter) Failures() (int, error) {
+	return w.failures.Consecutive()
+}
@@ -0,0 +1,154 @@
+// Package metrics holds the Prometheus collectors of the service. They are
+// registered in the default registry and served on /metrics by the health
+// server.
+package metrics
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "parsing"
+
+var (
+	FetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fetch_duration_seconds",
+		Help:      "Duration of a single HTTP fetch attempt, by shop host.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16},
+	}, []string{"host"})
+
+	FetchResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_responses_total",
+		Help:      "HTTP fetch attempts by shop host and status code (\"error\" when no response was received).",
+	}, []string{"host", "status"})
+
+	FetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_errors_total",
+		Help:      "Failed fetch attempts by shop host and kind (http_status, blocked, body_too_large, timeout, dns, tls, connection, circuit_open).",
+	}, []string{"host", "kind"})
+
+	FetchPhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fetch_phase_duration_seconds",
+		Help:      "Duration of the DNS, connect, TLS and time-to-first-byte phases of successful fetches, by shop host.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 4},
+	}, []string{"host", "phase"})
+
+	FetchTruncated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_truncated_total",
+		Help:      "Pages cut at max_body_bytes, by shop host.",
+	}, []string{"host"})
+
+	FetchCharsets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_charsets_total",
+		Help:      "Fetched pages by detected charset.",
+	}, []string{"charset"})
+
+	FetchRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_retries_total",
+		Help:      "Fetch attempts retried after a failure, by shop host.",
+	}, []string{"host"})
+
+	LimiterWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "limiter_wait_seconds",
+		Help:      "Time spent waiting for the per-domain rate limiter, by shop host.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+	}, []string{"host"})
+
+	LimiterInterval = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "limiter_interval_seconds",
+		Help:      "Current minimal interval between requests to a shop host.",
+	}, []string{"host"})
+
+	LimiterThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "limiter_throttled_total",
+		Help:      "429/503 responses that slowed a shop host down.",
+	}, []string{"host"})
+
+	BreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "Circuit breaker state of a shop host: 0 closed, 1 half-open, 2 open.",
+	}, []string{"host"})
+
+	BreakerOpened = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_opened_total",
+		Help:      "Times the circuit breaker of a shop host opened.",
+	}, []string{"host"})
+
+	Extractions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "extractions_total",
+		Help:      "Price extraction results by strategy (\"none\" when no strategy found a price, \"ambiguous\" when a strategy found a number it could not read).",
+	}, []string{"strategy", "result"})
+
+	Availability = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "availability_total",
+		Help:      "Extracted prices by product availability.",
+	}, []string{"availability"})
+
+	ConsumeLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_consume_lag",
+		Help:      "Messages behind the high watermark at the last fetch, by topic and partition.",
+	}, []string{"topic", "partition"})
+
+	ReadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_read_errors_total",
+		Help:      "Kafka fetch and unmarshal errors and requests that could not be delayed, by topic and kind.",
+	}, []string{"topic", "kind"})
+
+	PublishedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_published_messages_total",
+		Help:      "Messages written to Kafka, by topic.",
+	}, []string{"topic"})
+
+	PublishErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_publish_errors_total",
+		Help:      "Failed Kafka writes, by topic.",
+	}, []string{"topic"})
+
+	WorkersInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_in_flight",
+		Help:      "ParseRequested messages being processed right now.",
+	})
+)
+
+// suppressedTotal is the source of price_unchanged_suppressed_total. The
+// counter itself is registered once, so the source can be replaced when the
+// app is initialised again in the same process.
+var suppressedTotal atomic.Pointer[func() uint64]
+
+var _ = promauto.NewCounterFunc(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "price_unchanged_suppressed_total",
+	Help:      "Unchanged price measurements that were not published.",
+}, func() float64 {
+	if total := suppressedTotal.Load(); total != nil {
+		return float64((*total)())
+	}
+	return 0
+})
+
+// RegisterSuppressed exposes the number of unchanged prices that were not
+// published. A later call replaces the previous source.
+func RegisterSuppressed(total func() uint64) {
+	suppressedTotal.Store(&total)
+}
@@ -0,0 +1,31 @@
+package events
+
+import "time"
+
+type FailureCategory string
+
+const (
+	FailureInvalidRequest FailureCategory = "invalid_request"
+	FailureExpired        FailureCategory = "expired"
+	FailureNetwork        FailureCategory = "network"
+	FailureHTTPStatus     FailureCategory = "http_status"
+	FailureBlocked        FailureCategory = "blocked"
+	FailureNotFound       FailureCategory = "not_found"
+	FailureTooLarge       FailureCategory = "too_large"
+	FailureDisallowed     FailureCategory = "disallowed"
+	FailureExtraction     FailureCategory = "extraction"
+	FailurePublish        FailureCategory = "publish"
+)
+
+type ParseFailed struct {
+	EventID        string          `json:"event_id"`
+	OccurredAt     time.Time       `json:"occurred_at"`
+	CorrelationID  string          `json:"correlation_id"`
+	RequestEventID string          `json:"request_event_id"`
+	ProductID      string          `json:"product_id,omitempty"`
+	URL            string          `json:"url"`
+	Category       FailureCategory `json:"category"`
+	Reason         string          `json:"reason"`
+	HTTPStatus     int             `json:"http_status,omitempty"`
+	Attempts       int             `json:"attempts,omitempty"`
+}
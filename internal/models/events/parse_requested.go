@@ -10,5 +10,7 @@ type ParseRequested struct {
 	URL            string    `json:"url"`
 	ScheduledAt    time.Time `json:"scheduled_at,omitempty"`
 	Priority       int       `json:"priority,omitempty"`
+	// Force makes the request run even if the same event was already completed.
+	Force bool `json:"force,omitempty"`
 }
 
@@ -2,15 +2,43 @@ package events
 
 import "time"
 
+// PriceMeasuredSchemaVersion is the current version of PriceMeasured.
+// Version 2 added the exact price: PriceMinor, PriceExponent and PriceDecimal.
+const PriceMeasuredSchemaVersion = 2
+
 type PriceMeasured struct {
+	SchemaVersion int       `json:"schema_version"`
 	EventID       string    `json:"event_id"`
 	OccurredAt    time.Time `json:"occurred_at"`
 	CorrelationID string    `json:"correlation_id"`
 	ProductID     string    `json:"product_id,omitempty"`
-	Price         int64     `json:"price"`
+	// Price is in whole currency units, rounded half up. Kept for version 1
+	// consumers; use PriceMinor.
+	Price int64 `json:"price"`
+	// PriceMinor is the price in minor units (kopecks, cents):
+	// PriceMinor / 10^PriceExponent, where PriceExponent is the ISO 4217
+	// exponent of Currency. PriceDecimal is the same value as a string, e.g.
+	// "1234.50".
+	PriceMinor    int64     `json:"price_minor"`
+	PriceExponent int       `json:"price_exponent"`
+	PriceDecimal  string    `json:"price_decimal"`
 	Currency      string    `json:"currency"`
 	ParsedAt      time.Time `json:"parsed_at"`
 	SourceURL     string    `json:"source_url"`
 	MetaHash      string    `json:"meta_hash,omitempty"`
+	Strategy      string    `json:"strategy,omitempty"`
+	RawPrice      string    `json:"raw_price,omitempty"`
+	Confidence    string    `json:"confidence,omitempty"`
+	// Availability is one of in_stock, out_of_stock, preorder, discontinued
+	// or unknown. A price of a product that can not be bought is not an
+	// offer and should not trigger price alerts.
+	Availability string `json:"availability"`
+	// OldPriceMinor and OldPriceDecimal are the price before discount, in the
+	// same units as PriceMinor and PriceDecimal; DiscountPercent is
+	// (old - price) / old * 100 rounded to 0.1. All are omitted when the page
+	// shows no old price, and OnSale is set when it does.
+	OldPriceMinor   int64   `json:"old_price_minor,omitempty"`
+	OldPriceDecimal string  `json:"old_price_decimal,omitempty"`
+	DiscountPercent float64 `json:"discount_percent,omitempty"`
+	OnSale          bool    `json:"on_sale,omitempty"`
 }
-
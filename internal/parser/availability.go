@@ -0,0 +1,199 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Availability tells whether the product can be bought at the extracted price.
+type Availability string
+
+const (
+	AvailabilityUnknown      Availability = "unknown"
+	AvailabilityInStock      Availability = "in_stock"
+	AvailabilityOutOfStock   Availability = "out_of_stock"
+	AvailabilityPreOrder     Availability = "preorder"
+	AvailabilityDiscontinued Availability = "discontinued"
+)
+
+// availabilityValues maps schema.org ItemAvailability names, og:availability
+// values and common shop spellings, lower-cased without spaces, '-' and '_',
+// to Availability.
+var availabilityValues = map[string]Availability{
+	"instock":             AvailabilityInStock,
+	"onlineonly":          AvailabilityInStock,
+	"instoreonly":         AvailabilityInStock,
+	"limitedavailability": AvailabilityInStock,
+	"available":           AvailabilityInStock,
+	"вналичии":            AvailabilityInStock,
+	"есть":                AvailabilityInStock,
+	"outofstock":          AvailabilityOutOfStock,
+	"oos":                 AvailabilityOutOfStock,
+	"soldout":             AvailabilityOutOfStock,
+	"unavailable":         AvailabilityOutOfStock,
+	"notavailable":        AvailabilityOutOfStock,
+	"нетвналичии":         AvailabilityOutOfStock,
+	"нет":                 AvailabilityOutOfStock,
+	"preorder":            AvailabilityPreOrder,
+	"presale":             AvailabilityPreOrder,
+	"backorder":           AvailabilityPreOrder,
+	"availablefororder":   AvailabilityPreOrder,
+	"предзаказ":           AvailabilityPreOrder,
+	"подзаказ":            AvailabilityPreOrder,
+	"discontinued":        AvailabilityDiscontinued,
+	"снятспроизводства":   AvailabilityDiscontinued,
+}
+
+var (
+	// availabilityKeys hold a status string in embedded JSON.
+	availabilityKeys = []string{"availability", "availabilityStatus", "availability_status", "stockStatus", "stock_status", "stockState", "stock_state"}
+	// inStockKeys hold a boolean.
+	inStockKeys = []string{"inStock", "in_stock", "isInStock", "is_in_stock", "isAvailable", "is_available", "available"}
+	// stockQuantityKeys hold the number of items left.
+	stockQuantityKeys = []string{"stockQuantity", "stock_quantity", "quantityAvailable", "quantity_available", "availableQuantity", "available_quantity"}
+)
+
+// availabilityMarkers are phrases in the visible text of a page that mean the
+// product can not be bought. Discontinued ones are checked first. "В наличии"
+// is not a marker: it is also a common filter label.
+var availabilityMarkers = []struct {
+	phrase string
+	value  Availability
+}{
+	{"снят с производства", AvailabilityDiscontinued},
+	{"больше не производится", AvailabilityDiscontinued},
+	{"снят с продажи", AvailabilityDiscontinued},
+	{"нет в наличии", AvailabilityOutOfStock},
+	{"нет на складе", AvailabilityOutOfStock},
+	{"товар закончился", AvailabilityOutOfStock},
+	{"нет в продаже", AvailabilityOutOfStock},
+	{"распродан", AvailabilityOutOfStock},
+	{"out of stock", AvailabilityOutOfStock},
+	{"sold out", AvailabilityOutOfStock},
+}
+
+// parseAvailability reads a status such as "https://schema.org/InStock",
+// "out of stock" or "нет в наличии".
+func parseAvailability(s string) (Availability, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	// "https://schema.org/InStock", "schema:InStock"
+	if i := strings.LastIndexAny(s, "/:"); i >= 0 {
+		s = s[i+1:]
+	}
+	s = strings.NewReplacer(" ", "", "-", "", "_", "", ".", "").Replace(s)
+	a, ok := availabilityValues[s]
+	return a, ok
+}
+
+// findAvailability looks for the availability of the product in scope: the
+// offer holding the price and its product in embedded JSON, then microdata
+// (itemprop="availability") around the price, then og:availability and
+// product:availability meta tags of the page, then out-of-stock and
+// discontinued phrases in the block around the price. Flags elsewhere on the
+// page, such as {"delivery":{"available":false}} or a "нет в наличии" filter,
+// are not read.
+func findAvailability(b []byte, scope priceScope) Availability {
+	for _, obj := range scope.objects {
+		if a, ok := availabilityInJSON(obj); ok {
+			return a
+		}
+	}
+	if scope.container != nil {
+		if a, ok := availabilityFromMicrodata(scope.container); ok {
+			return a
+		}
+	}
+	if a, ok := availabilityFromMeta(b); ok {
+		return a
+	}
+	if scope.container != nil {
+		text := strings.ToLower(nodeText(scope.container))
+		for _, m := range availabilityMarkers {
+			if strings.Contains(text, m.phrase) {
+				return m.value
+			}
+		}
+	}
+	return AvailabilityUnknown
+}
+
+// availabilityFromMeta reads the og:availability and product:availability
+// meta tags, which describe the product of the whole page.
+func availabilityFromMeta(b []byte) (Availability, bool) {
+	z := html.NewTokenizer(bytes.NewReader(b))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return "", false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			if t.Data != "meta" {
+				continue
+			}
+			var name, value string
+			for _, a := range t.Attr {
+				switch strings.ToLower(a.Key) {
+				case "property":
+					name = strings.ToLower(strings.TrimSpace(a.Val))
+				case "content":
+					value = a.Val
+				}
+			}
+			switch name {
+			case "og:availability", "product:availability":
+				if a, ok := parseAvailability(value); ok {
+					return a, true
+				}
+			}
+		}
+	}
+}
+
+// availabilityFromMicrodata reads the first <meta> or <link>
+// itemprop="availability" inside n.
+func availabilityFromMicrodata(n *html.Node) (Availability, bool) {
+	var a Availability
+	found := findNode(n, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || (n.DataAtom != atom.Meta && n.DataAtom != atom.Link) {
+			return false
+		}
+		if !strings.EqualFold(strings.TrimSpace(attr(n, "itemprop")), "availability") {
+			return false
+		}
+		var ok bool
+		a, ok = parseAvailability(firstNonEmptyString(attr(n, "content"), attr(n, "href")))
+		return ok
+	})
+	return a, found != nil
+}
+
+// availabilityInJSON reads the status, in-stock flag or stock quantity of an
+// offer or product object. Nested objects are not searched: a delivery option
+// or another product may have flags of their own.
+func availabilityInJSON(x map[string]any) (Availability, bool) {
+	if s, ok := firstKey(x, availabilityKeys...); ok {
+		if a, ok := parseAvailability(toString(s)); ok {
+			return a, true
+		}
+	}
+	if s, ok := firstKey(x, inStockKeys...); ok {
+		if in, ok := s.(bool); ok {
+			if in {
+				return AvailabilityInStock, true
+			}
+			return AvailabilityOutOfStock, true
+		}
+	}
+	if s, ok := firstKey(x, stockQuantityKeys...); ok {
+		if n, ok := s.(float64); ok {
+			if n > 0 {
+				return AvailabilityInStock, true
+			}
+			return AvailabilityOutOfStock, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,183 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LehaAlexey/Parsing/internal/metrics"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+var breakerStateValue = map[BreakerState]float64{
+	BreakerClosed:   0,
+	BreakerHalfOpen: 1,
+	BreakerOpen:     2,
+}
+
+// CircuitOpenError is returned without contacting the shop while its circuit
+// breaker is open.
+type CircuitOpenError struct {
+	Host    string
+	RetryIn time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s, retry in %s", e.Host, e.RetryIn.Round(time.Second))
+}
+
+// BreakerStatus is a snapshot of the circuit breaker of one host.
+type BreakerStatus struct {
+	Host     string       `json:"host"`
+	State    BreakerState `json:"state"`
+	Failures int          `json:"failures"`
+	OpenedAt time.Time    `json:"opened_at,omitzero"`
+	LastErr  string       `json:"last_error,omitempty"`
+}
+
+type hostBreaker struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int
+	lastErr  string
+}
+
+// circuitBreaker stops fetching from a host after threshold consecutive
+// failures that look like the shop being down (network errors and 5xx). After
+// coolDown it lets halfOpenRequests probes through: a success closes the
+// circuit, a failure opens it again.
+type circuitBreaker struct {
+	threshold        int
+	coolDown         time.Duration
+	halfOpenRequests int
+	mu               sync.Mutex
+	hosts            map[string]*hostBreaker
+}
+
+func newCircuitBreaker(threshold int, coolDown time.Duration, halfOpenRequests int) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:        threshold,
+		coolDown:         coolDown,
+		halfOpenRequests: halfOpenRequests,
+		hosts:            make(map[string]*hostBreaker),
+	}
+}
+
+// Allow must be followed by Record when it returns nil.
+func (b *circuitBreaker) Allow(host string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h, ok := b.hosts[host]
+	if !ok {
+		h = &hostBreaker{state: BreakerClosed}
+		b.hosts[host] = h
+	}
+
+	switch h.state {
+	case BreakerOpen:
+		if wait := b.coolDown - time.Since(h.openedAt); wait > 0 {
+			return &CircuitOpenError{Host: host, RetryIn: wait}
+		}
+		b.setState(host, h, BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if h.probes >= b.halfOpenRequests {
+			return &CircuitOpenError{Host: host, RetryIn: 0}
+		}
+		h.probes++
+	}
+	return nil
+}
+
+func (b *circuitBreaker) Record(host string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h, ok := b.hosts[host]
+	if !ok {
+		return
+	}
+	if h.state == BreakerHalfOpen && h.probes > 0 {
+		h.probes--
+	}
+	// the caller gave up, this says nothing about the host
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return
+	}
+
+	if !isHostDown(err) {
+		h.failures = 0
+		if h.state != BreakerClosed {
+			b.setState(host, h, BreakerClosed)
+		}
+		return
+	}
+
+	h.failures++
+	h.lastErr = err.Error()
+	if h.state == BreakerHalfOpen || (h.state == BreakerClosed && h.failures >= b.threshold) {
+		h.openedAt = time.Now()
+		b.setState(host, h, BreakerOpen)
+		metrics.BreakerOpened.WithLabelValues(host).Inc()
+	}
+}
+
+func (b *circuitBreaker) setState(host string, h *hostBreaker, st BreakerState) {
+	h.state = st
+	h.probes = 0
+	metrics.BreakerState.WithLabelValues(host).Set(breakerStateValue[st])
+}
+
+// Status returns the breakers that are not closed or have recent failures.
+func (b *circuitBreaker) Status() []BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make([]BreakerStatus, 0)
+	for host, h := range b.hosts {
+		if h.state == BreakerClosed && h.failures == 0 {
+			continue
+		}
+		st := BreakerStatus{Host: host, State: h.state, Failures: h.failures, LastErr: h.lastErr}
+		if h.state != BreakerClosed {
+			st.OpenedAt = h.openedAt
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// isHostDown reports whether err means the shop itself is unavailable, as
+// opposed to a problem with the particular page.
+func isHostDown(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr *NetworkError
+	if errors.As(err, &netErr) {
+		return netErr.Kind != NetworkTLS
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		// 503 with Retry-After is throttling, handled by the limiter
+		if statusErr.StatusCode == http.StatusServiceUnavailable && statusErr.Header.Get("Retry-After") != "" {
+			return false
+		}
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package parser
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// charsetSniffBytes bounds the search for <meta charset>; shops with long
+	// heads declare it later than the 1024 bytes the HTML spec asks for.
+	charsetSniffBytes = 64 << 10
+	// fallbackCharset is used for pages without a declaration that are not
+	// valid UTF-8. Old Russian shops are the usual case.
+	fallbackCharset = "windows-1251"
+	// maxInvalidUTF8Percent is how many invalid bytes, per hundred valid
+	// multi-byte characters, an undeclared UTF-8 page may have: a stray byte
+	// from a legacy template must not turn the whole page into mojibake.
+	maxInvalidUTF8Percent = 1
+)
+
+var boms = []struct {
+	bom     []byte
+	charset string
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, "utf-8"},
+	{[]byte{0xFE, 0xFF}, "utf-16be"},
+	{[]byte{0xFF, 0xFE}, "utf-16le"},
+}
+
+// detectCharset returns the charset of an HTML page from, in order, the BOM,
+// the Content-Type header and <meta charset> / http-equiv, falling back to
+// UTF-8 if the body is (almost) valid UTF-8 and windows-1251 otherwise.
+func detectCharset(body []byte, contentType string) string {
+	for _, b := range boms {
+		if bytes.HasPrefix(body, b.bom) {
+			return b.charset
+		}
+	}
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if name, ok := knownCharset(params["charset"]); ok {
+			return name
+		}
+	}
+	if name, ok := metaCharset(body); ok {
+		return name
+	}
+	if looksUTF8(body) {
+		return "utf-8"
+	}
+	return fallbackCharset
+}
+
+// looksUTF8 reports whether b is UTF-8 with at most maxInvalidUTF8Percent
+// invalid bytes. Legacy Cyrillic text is almost never valid UTF-8, so it has
+// far more invalid bytes than valid characters.
+func looksUTF8(b []byte) bool {
+	var valid, invalid int
+	for len(b) > 0 {
+		if b[0] < utf8.RuneSelf {
+			b = b[1:]
+			continue
+		}
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			invalid++
+		} else {
+			valid++
+		}
+		b = b[size:]
+	}
+	return invalid*100 <= valid*maxInvalidUTF8Percent
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence the body limit left at
+// the end of b, so the cut alone does not make a UTF-8 page look invalid.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c < utf8.RuneSelf {
+			return b
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			return b
+		}
+	}
+	return b
+}
+
+// toUTF8 transcodes body from the detected charset. The BOM is dropped.
+func toUTF8(body []byte, contentType string) ([]byte, string) {
+	name := detectCharset(body, contentType)
+	for _, b := range boms {
+		if b.charset == name {
+			body = bytes.TrimPrefix(body, b.bom)
+		}
+	}
+	if name == "utf-8" {
+		if !utf8.Valid(body) {
+			body = bytes.ToValidUTF8(body, []byte("\uFFFD"))
+		}
+		return body, name
+	}
+
+	enc, _ := charset.Lookup(name)
+	out, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body, name
+	}
+	return out, name
+}
+
+func knownCharset(label string) (string, bool) {
+	label = strings.TrimSpace(strings.Trim(label, `"'`))
+	if label == "" {
+		return "", false
+	}
+	enc, name := charset.Lookup(label)
+	if enc == nil {
+		return "", false
+	}
+	return name, true
+}
+
+func metaCharset(body []byte) (string, bool) {
+	if len(body) > charsetSniffBytes {
+		body = body[:charsetSniffBytes]
+	}
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return "", false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "body":
+				return "", false
+			case "meta":
+			default:
+				continue
+			}
+
+			var httpEquiv, content string
+			for _, a := range t.Attr {
+				switch strings.ToLower(a.Key) {
+				case "charset":
+					if name, ok := knownCharset(a.Val); ok {
+						return metaOverride(name), true
+					}
+				case "http-equiv":
+					httpEquiv = strings.ToLower(a.Val)
+				case "content":
+					content = a.Val
+				}
+			}
+			if httpEquiv == "content-type" {
+				if _, params, err := mime.ParseMediaType(content); err == nil {
+					if name, ok := knownCharset(params["charset"]); ok {
+						return metaOverride(name), true
+					}
+				}
+			}
+		}
+	}
+}
+
+// metaOverride follows the HTML spec: markup readable as ASCII can not really
+// be UTF-16, whatever it declares.
+func metaOverride(name string) string {
+	if strings.HasPrefix(name, "utf-16") {
+		return "utf-8"
+	}
+	return name
+}
@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxDecimalDigits keeps the unscaled value of a Decimal within int64.
+const maxDecimalDigits = 18
+
+// Decimal is an exact decimal number: Value * 10^-Scale.
+type Decimal struct {
+	Value int64
+	Scale int
+}
+
+// parseDecimal parses plain digits with an optional '.' fraction, e.g.
+// "1234.50". Trailing fractional zeros are kept in Scale.
+func parseDecimal(s string) (Decimal, bool) {
+	intPart, frac, _ := strings.Cut(s, ".")
+	digits := strings.TrimLeft(intPart+frac, "0")
+	if intPart+frac == "" || len(digits) > maxDecimalDigits {
+		return Decimal{}, false
+	}
+	if digits == "" {
+		return Decimal{Scale: len(frac)}, true
+	}
+	v, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Decimal{}, false
+	}
+	return Decimal{Value: v, Scale: len(frac)}, true
+}
+
+// Normalize drops trailing fractional zeros: 99.90 becomes 99.9.
+func (d Decimal) Normalize() Decimal {
+	for d.Scale > 0 && d.Value%10 == 0 {
+		d.Value /= 10
+		d.Scale--
+	}
+	return d
+}
+
+// Rescale returns the value in units of 10^-scale, rounding half up when
+// digits are dropped. ok is false on overflow.
+func (d Decimal) Rescale(scale int) (int64, bool) {
+	v := d.Value
+	for s := d.Scale; s < scale; s++ {
+		if v > (1<<63-1)/10 {
+			return 0, false
+		}
+		v *= 10
+	}
+	for s := d.Scale; s > scale; s-- {
+		last := v % 10
+		v /= 10
+		if s == scale+1 && last >= 5 {
+			v++
+		}
+	}
+	return v, true
+}
+
+// Round returns the value rounded half up to whole units.
+func (d Decimal) Round() int64 {
+	v, _ := d.Rescale(0)
+	return v
+}
+
+// String formats the number with exactly Scale fractional digits.
+func (d Decimal) String() string {
+	return formatScaled(d.Value, d.Scale)
+}
+
+// Format formats the number with exactly scale fractional digits, rounding
+// half up.
+func (d Decimal) Format(scale int) string {
+	v, ok := d.Rescale(scale)
+	if !ok {
+		return d.String()
+	}
+	return formatScaled(v, scale)
+}
+
+func formatScaled(v int64, scale int) string {
+	s := strconv.FormatInt(v, 10)
+	if scale <= 0 {
+		return s
+	}
+	if len(s) <= scale {
+		s = strings.Repeat("0", scale-len(s)+1) + s
+	}
+	return s[:len(s)-scale] + "." + s[len(s)-scale:]
+}
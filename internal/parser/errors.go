@@ -0,0 +1,233 @@
+package parser
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// HTTPStatusError is returned when the shop answers with a non-2xx status.
+type HTTPStatusError struct {
+	StatusCode int
+	Header     http.Header
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("http status %d", e.StatusCode)
+}
+
+// BlockedError is returned when the response is an anti-bot challenge or a
+// captcha page instead of the product page.
+type BlockedError struct {
+	StatusCode int
+	Header     http.Header
+	Reason     string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("blocked by shop (http status %d): %s", e.StatusCode, e.Reason)
+}
+
+// BodyTooLargeError is returned when the body exceeds the limit of the host
+// and the truncate policy is TruncateFail. Size is zero when the shop did not
+// declare Content-Length.
+type BodyTooLargeError struct {
+	Size  int64
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	if e.Size <= 0 {
+		return fmt.Sprintf("body too large: over limit %d", e.Limit)
+	}
+	return fmt.Sprintf("body too large: %d bytes, limit %d", e.Size, e.Limit)
+}
+
+// DisallowedError is returned without fetching when robots.txt of the shop
+// forbids the URL for our user agent.
+type DisallowedError struct {
+	URL string
+}
+
+func (e *DisallowedError) Error() string {
+	return fmt.Sprintf("disallowed by robots.txt: %s", e.URL)
+}
+
+// RobotsUnavailableError is returned without fetching while robots.txt of the
+// shop can not be downloaded (network error or 5xx). The shop is likely down,
+// so none of its pages are fetched until robots.txt answers again. Err is the
+// *NetworkError or *HTTPStatusError of the download.
+type RobotsUnavailableError struct {
+	URL string
+	Err error
+}
+
+func (e *RobotsUnavailableError) Error() string {
+	return fmt.Sprintf("robots.txt unreachable for %s: %v", e.URL, e.Err)
+}
+
+func (e *RobotsUnavailableError) Unwrap() error { return e.Err }
+
+// ForbiddenAddressError is returned when the URL points to, or resolves to,
+// a loopback, private, link-local or otherwise non-public address.
+type ForbiddenAddressError struct {
+	Addr string
+}
+
+func (e *ForbiddenAddressError) Error() string {
+	return fmt.Sprintf("forbidden address: %s", e.Addr)
+}
+
+// NetworkErrorKind tells transient network failures from permanent ones.
+type NetworkErrorKind string
+
+const (
+	NetworkTimeout    NetworkErrorKind = "timeout"
+	NetworkDNS        NetworkErrorKind = "dns"
+	NetworkTLS        NetworkErrorKind = "tls"
+	NetworkConnection NetworkErrorKind = "connection"
+)
+
+// NetworkError is returned when no HTTP response was received.
+type NetworkError struct {
+	Kind NetworkErrorKind
+	// Permanent is set for failures that will not go away on retry: invalid
+	// certificates and unknown hosts.
+	Permanent bool
+	Err       error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("%s error: %v", e.Kind, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error { return e.Err }
+
+func (e *NetworkError) Timeout() bool { return e.Kind == NetworkTimeout }
+
+// FetchError wraps the last error of a fetch that ran out of attempts or hit
+// a permanent failure.
+type FetchError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("%v (attempts: %d)", e.Err, e.Attempts)
+}
+
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// IsRetryable is the retry policy of the fetcher: server errors, throttling
+// and transient network failures are retried; missing pages, client errors,
+// blocks, oversized bodies, bad certificates, unknown hosts, open circuits
+// and forbidden addresses are not.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var blockedErr *BlockedError
+	if errors.As(err, &blockedErr) {
+		return false
+	}
+	var openErr *CircuitOpenError
+	if errors.As(err, &openErr) {
+		return false
+	}
+	var disallowedErr *DisallowedError
+	if errors.As(err, &disallowedErr) {
+		return false
+	}
+	var tooLargeErr *BodyTooLargeError
+	if errors.As(err, &tooLargeErr) {
+		return false
+	}
+	var forbiddenErr *ForbiddenAddressError
+	if errors.As(err, &forbiddenErr) {
+		return false
+	}
+	var netErr *NetworkError
+	if errors.As(err, &netErr) {
+		return !netErr.Permanent
+	}
+	return true
+}
+
+// classifyNetworkError wraps an error of http.Client.Do.
+func classifyNetworkError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	var (
+		dnsErr      *net.DNSError
+		certErr     *tls.CertificateVerificationError
+		unknownAuth x509.UnknownAuthorityError
+		hostErr     x509.HostnameError
+		invalidErr  x509.CertificateInvalidError
+		recordErr   tls.RecordHeaderError
+		netErr      net.Error
+		forbidden   *ForbiddenAddressError
+	)
+	switch {
+	case errors.As(err, &forbidden):
+		return forbidden
+	case errors.As(err, &certErr), errors.As(err, &unknownAuth), errors.As(err, &hostErr), errors.As(err, &invalidErr), errors.As(err, &recordErr):
+		return &NetworkError{Kind: NetworkTLS, Permanent: true, Err: err}
+	case errors.As(err, &dnsErr):
+		return &NetworkError{Kind: NetworkDNS, Permanent: dnsErr.IsNotFound, Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &NetworkError{Kind: NetworkTimeout, Err: err}
+	default:
+		return &NetworkError{Kind: NetworkConnection, Err: err}
+	}
+}
+
+// errorKind is the label of a failed fetch attempt in metrics.
+func errorKind(err error) string {
+	var (
+		statusErr   *HTTPStatusError
+		blockedErr  *BlockedError
+		tooLargeErr *BodyTooLargeError
+		netErr      *NetworkError
+		openErr     *CircuitOpenError
+		disallowed  *DisallowedError
+		robotsErr   *RobotsUnavailableError
+		forbidden   *ForbiddenAddressError
+	)
+	switch {
+	case errors.As(err, &robotsErr):
+		return "robots_unreachable"
+	case errors.As(err, &disallowed):
+		return "disallowed"
+	case errors.As(err, &forbidden):
+		return "forbidden_address"
+	case errors.As(err, &openErr):
+		return "circuit_open"
+	case errors.As(err, &blockedErr):
+		return "blocked"
+	case errors.As(err, &statusErr):
+		return "http_status"
+	case errors.As(err, &tooLargeErr):
+		return "body_too_large"
+	case errors.As(err, &netErr):
+		return string(netErr.Kind)
+	default:
+		return "other"
+	}
+}
@@ -3,63 +3,191 @@ package parser
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/LehaAlexey/Parsing/internal/currency"
+	"github.com/LehaAlexey/Parsing/internal/metrics"
 	"golang.org/x/net/html"
 )
 
+// Strategy names the extraction step that produced a price.
+type Strategy string
+
+const (
+	StrategyRule         Strategy = "rule"
+	StrategyMeta         Strategy = "meta"
+	StrategyJSONLD       Strategy = "json_ld"
+	StrategyScriptJSON   Strategy = "script_json"
+	StrategyCurrencyText Strategy = "currency_text"
+	StrategyPriceRegex   Strategy = "price_regex"
+)
+
+// Confidence is a coarse estimate of how likely the price is the real product
+// price. Structured markup is trusted more than free-text matches.
+type Confidence string
+
+const (
+	ConfidenceHigh   Confidence = "high"
+	ConfidenceMedium Confidence = "medium"
+	ConfidenceLow    Confidence = "low"
+)
+
+var strategyConfidence = map[Strategy]Confidence{
+	StrategyRule:         ConfidenceHigh,
+	StrategyMeta:         ConfidenceHigh,
+	StrategyJSONLD:       ConfidenceHigh,
+	StrategyScriptJSON:   ConfidenceMedium,
+	StrategyCurrencyText: ConfidenceLow,
+	StrategyPriceRegex:   ConfidenceLow,
+}
+
+type Result struct {
+	// Amount is the exact price as found on the page.
+	Amount Decimal
+	// OldPrice is the price before discount, zero when the page shows none.
+	OldPrice   Decimal
+	Currency   string
+	Strategy   Strategy
+	Rule       string
+	Raw        string
+	Confidence Confidence
+	// Availability is AvailabilityUnknown when the page does not tell.
+	Availability Availability
+}
+
+// Price is Amount rounded to whole units.
+func (r Result) Price() int64 {
+	return r.Amount.Round()
+}
+
 type Extractor struct {
 	priceRe *regexp.Regexp
+	rules   []compiledRule
 }
 
-func NewExtractor() *Extractor {
+func NewExtractor(rules ...Rule) (*Extractor, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, r := range rules {
+		cr, err := compileRule(r)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, cr)
+	}
+
 	return &Extractor{
-		priceRe: regexp.MustCompile(`(?i)(?:price|amount)[^0-9]{0,20}([0-9][0-9\s.,]{0,20})`),
+		priceRe: regexp.MustCompile(`(?i)(?:price|amount)[^0-9]{0,20}(` + numberPattern + `)`),
+		rules:   compiled,
+	}, nil
+}
+
+func (e *Extractor) Extract(pageURL string, htmlBytes []byte) (Result, bool) {
+	res, scope, ok := e.extract(pageURL, htmlBytes)
+	if ok {
+		res.Availability = findAvailability(htmlBytes, scope)
+		metrics.Availability.WithLabelValues(string(res.Availability)).Inc()
+		metrics.Extractions.WithLabelValues(string(res.Strategy), "success").Inc()
+	} else {
+		metrics.Extractions.WithLabelValues("none", "failure").Inc()
 	}
+	return res, ok
 }
 
-func (e *Extractor) Extract(htmlBytes []byte) (int64, string, bool) {
+func (e *Extractor) extract(pageURL string, htmlBytes []byte) (Result, priceScope, bool) {
 	if len(htmlBytes) == 0 {
-		return 0, "", false
+		return Result{}, priceScope{}, false
 	}
 
-	priceStr, currency, ok := extractFromMeta(htmlBytes)
-	if ok {
-		if p, ok := parsePriceInt64(priceStr); ok {
-			return p, normalizeCurrency(currency), true
+	hints := pageHints(pageURL, htmlBytes)
+	if m, ok := extractWithRules(e.rules, pageURL, htmlBytes); ok {
+		if res, ok := newResult(StrategyRule, m.price, m.currency, hints); ok {
+			res.Rule = m.rule
+			scope := findPriceScope(htmlBytes, res, m.node)
+			hints.currency = res.Currency
+			if old, ok := parseOldPrice(m.oldPrice, res.Amount, hints); ok {
+				res.OldPrice = old
+				return res, scope, true
+			}
+			return withOldPrice(res, scope, hints), scope, true
 		}
 	}
 
-	priceStr, currency, ok = extractFromJSONLD(htmlBytes)
-	if ok {
-		if p, ok := parsePriceInt64(priceStr); ok {
-			return p, normalizeCurrency(currency), true
-		}
+	res, ok := e.extractHeuristics(htmlBytes, hints)
+	if !ok {
+		return Result{}, priceScope{}, false
 	}
+	scope := findPriceScope(htmlBytes, res, nil)
+	return withOldPrice(res, scope, hints), scope, true
+}
 
-	priceStr, currency, ok = extractFromScriptJSON(htmlBytes)
-	if ok {
-		if p, ok := parsePriceInt64(priceStr); ok {
-			return p, normalizeCurrency(currency), true
-		}
+func withOldPrice(res Result, scope priceScope, hints numberHints) Result {
+	hints.currency = res.Currency
+	if old, ok := findOldPrice(scope, res.Amount, hints); ok {
+		res.OldPrice = old
 	}
+	return res
+}
 
-	priceStr, currency, ok = extractFromTextWithCurrency(htmlBytes)
-	if ok {
-		if p, ok := parsePriceInt64(priceStr); ok {
-			return p, normalizeCurrency(currency), true
+func (e *Extractor) extractHeuristics(htmlBytes []byte, hints numberHints) (Result, bool) {
+	strategies := []struct {
+		name    Strategy
+		extract func([]byte) (string, string, bool)
+		// machine is set for strategies reading schema.org and Open Graph
+		// values, which use '.' as the decimal separator.
+		machine bool
+	}{
+		{StrategyMeta, extractFromMeta, true},
+		{StrategyJSONLD, extractFromJSONLD, true},
+		{StrategyScriptJSON, extractFromScriptJSON, false},
+		{StrategyCurrencyText, extractFromTextWithCurrency, false},
+	}
+	for _, st := range strategies {
+		priceStr, currency, ok := st.extract(htmlBytes)
+		if !ok {
+			continue
+		}
+		h := hints
+		h.machine = st.machine || st.name == StrategyScriptJSON && isJSONNumber(htmlBytes, priceStr)
+		if res, ok := newResult(st.name, priceStr, currency, h); ok {
+			return res, true
 		}
 	}
 
-	if m := e.priceRe.FindSubmatch(htmlBytes); len(m) >= 2 {
-		if p, ok := parsePriceInt64(string(m[1])); ok {
-			return p, "", true
+	if m := e.priceRe.FindSubmatchIndex(htmlBytes); m != nil {
+		after := htmlBytes[m[3]:min(m[3]+32, len(htmlBytes))]
+		cur := currency.Prefix(string(after))
+		if res, ok := newResult(StrategyPriceRegex, string(htmlBytes[m[2]:m[3]]), cur, hints); ok {
+			return res, true
 		}
 	}
 
-	return 0, "", false
+	return Result{}, false
+}
+
+func newResult(strategy Strategy, raw, cur string, hints numberHints) (Result, bool) {
+	cur = normalizeCurrency(cur)
+	if cur == "" {
+		// "1 990 ₽" in a price field
+		cur = currency.Detect(raw)
+	}
+	hints.currency = cur
+	p, err := parseNumber(raw, hints)
+	if err != nil || p.Value <= 0 {
+		if errors.Is(err, errAmbiguousNumber) {
+			metrics.Extractions.WithLabelValues(string(strategy), "ambiguous").Inc()
+		}
+		return Result{}, false
+	}
+	return Result{
+		Amount:     p,
+		Currency:   cur,
+		Strategy:   strategy,
+		Raw:        strings.TrimSpace(raw),
+		Confidence: strategyConfidence[strategy],
+	}, true
 }
 
 func extractFromMeta(b []byte) (string, string, bool) {
@@ -182,58 +310,116 @@ func extractFromScriptJSON(b []byte) (string, string, bool) {
 }
 
 func parseEmbeddedJSON(raw string) (string, string, bool) {
-	var v any
-	if err := json.Unmarshal([]byte(raw), &v); err == nil {
-		if price, currency, ok := findPriceCurrency(v); ok && price != "" {
-			return price, currency, true
+	v, ok := decodeScriptJSON(raw)
+	if !ok {
+		return "", "", false
+	}
+	if price, currency, ok := findPriceCurrency(v); ok && price != "" {
+		return price, currency, true
+	}
+	return "", "", false
+}
+
+// isJSONNumber reports whether the price raw found by extractFromScriptJSON
+// is a JSON number rather than a string, which may be formatted for people.
+func isJSONNumber(b []byte, raw string) bool {
+	for _, v := range scriptJSONs(b) {
+		if objs := priceObjects(v, raw); objs != nil {
+			p, _ := firstKey(objs[0], priceKeys...)
+			_, ok := p.(float64)
+			return ok
 		}
 	}
+	return false
+}
 
-	start := strings.Index(raw, "{")
-	if start == -1 {
-		return "", "", false
+// decodeScriptJSON parses raw as JSON, or the outermost {...} fragment of a
+// script assigning it.
+func decodeScriptJSON(raw string) (any, bool) {
+	var v any
+	if raw == "" {
+		return nil, false
 	}
-	end := strings.LastIndex(raw, "}")
-	if end <= start {
-		return "", "", false
+	if err := json.Unmarshal([]byte(raw), &v); err == nil {
+		return v, true
 	}
-	fragment := strings.TrimSpace(raw[start : end+1])
-
-	if err := json.Unmarshal([]byte(fragment), &v); err != nil {
-		return "", "", false
+	start, end := strings.Index(raw, "{"), strings.LastIndex(raw, "}")
+	if start == -1 || end <= start {
+		return nil, false
 	}
-	if price, currency, ok := findPriceCurrency(v); ok && price != "" {
-		return price, currency, true
+	if err := json.Unmarshal([]byte(raw[start:end+1]), &v); err != nil {
+		return nil, false
 	}
-
-	return "", "", false
+	return v, true
 }
 
+var (
+	numberThenCurrencyRe = regexp.MustCompile(`(` + numberPattern + `)\s*(` + currency.Pattern() + `)(?:[^\p{L}]|$)`)
+	currencyThenNumberRe = regexp.MustCompile(`(?:^|[^\p{L}])(` + currency.LeadingPattern() + `)\s*(` + numberPattern + `)`)
+)
+
+// extractFromTextWithCurrency finds the first number written next to a
+// currency in the visible text of the page. Names that are also common words
+// only count right after the number: "Shower gel 250 ml" is not a price in
+// GEL.
 func extractFromTextWithCurrency(b []byte) (string, string, bool) {
-	patterns := []struct {
-		re       *regexp.Regexp
-		currency string
-	}{
-		{regexp.MustCompile(`(?i)(?:rub|rur)\s*([0-9][0-9\s.,]{0,20})`), "RUB"},
-		{regexp.MustCompile(`(?i)([0-9][0-9\s.,]{0,20})\s*(?:rub|rur)`), "RUB"},
-		{regexp.MustCompile(`(?i)(?:usd|\$|dollars?)\s*([0-9][0-9\s.,]{0,20})`), "USD"},
-		{regexp.MustCompile(`(?i)([0-9][0-9\s.,]{0,20})\s*(?:usd|\$|dollars?)`), "USD"},
-		{regexp.MustCompile(`(?i)(?:eur|euros?)\s*([0-9][0-9\s.,]{0,20})`), "EUR"},
-		{regexp.MustCompile(`(?i)([0-9][0-9\s.,]{0,20})\s*(?:eur|euros?)`), "EUR"},
+	text := visibleText(b)
+	price, cur, at := "", "", -1
+	if m := numberThenCurrencyRe.FindStringSubmatchIndex(text); m != nil {
+		price, cur, at = text[m[2]:m[3]], text[m[4]:m[5]], m[0]
+	}
+	if m := currencyThenNumberRe.FindStringSubmatchIndex(text); m != nil && (at < 0 || m[2] < at) {
+		price, cur, at = text[m[4]:m[5]], text[m[2]:m[3]], m[2]
 	}
-	text := string(b)
-	for _, p := range patterns {
-		if m := p.re.FindStringSubmatch(text); len(m) >= 2 {
-			return m[1], p.currency, true
+	if at < 0 {
+		return "", "", false
+	}
+	return price, currency.Normalize(cur), true
+}
+
+// visibleText joins the text nodes of the page outside <script>, <style> and
+// crossed-out elements, so that an old price is not taken for the price.
+func visibleText(b []byte) string {
+	var sb strings.Builder
+	z := html.NewTokenizer(bytes.NewReader(b))
+	skip := false
+	// depth inside a crossed-out element
+	struck := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return sb.String()
+		case html.StartTagToken:
+			t := z.Token()
+			if voidElements[t.Data] {
+				continue
+			}
+			if struck > 0 {
+				struck++
+				continue
+			}
+			skip = t.Data == "script" || t.Data == "style"
+			if isStruck(t.Data, t.Attr) {
+				struck = 1
+			}
+		case html.EndTagToken:
+			skip = false
+			if struck > 0 {
+				struck--
+			}
+		case html.TextToken:
+			if !skip && struck == 0 {
+				sb.Write(z.Text())
+				sb.WriteByte(' ')
+			}
 		}
 	}
-	return "", "", false
 }
 
 func findPriceCurrency(v any) (string, string, bool) {
 	switch x := v.(type) {
 	case map[string]any:
-		if p, ok := firstKey(x, "price", "priceValue", "price_value", "priceNumeric", "price_num", "amount", "value"); ok {
+		if p, ok := firstKey(x, priceKeys...); ok {
 			price := toString(p)
 			if price == "" {
 				return "", "", false
@@ -288,51 +474,11 @@ func toString(v any) string {
 	}
 }
 
+// normalizeCurrency maps a currency found in markup to ISO 4217; unknown
+// values are dropped.
 func normalizeCurrency(s string) string {
-	s = strings.TrimSpace(strings.ToUpper(s))
-	if s == "" {
-		return ""
-	}
-	if s == "RUR" {
-		return "RUB"
-	}
-	if strings.Contains(s, "RUB") {
-		return "RUB"
-	}
-	return s
-}
-
-func parsePriceInt64(s string) (int64, bool) {
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return 0, false
-	}
-	s = strings.ReplaceAll(s, "\u00A0", "")
-	s = strings.ReplaceAll(s, " ", "")
-	s = strings.ReplaceAll(s, "RUB", "")
-	s = strings.ReplaceAll(s, "RUR", "")
-	s = strings.ReplaceAll(s, "USD", "")
-	s = strings.ReplaceAll(s, "EUR", "")
-	s = strings.ReplaceAll(s, ",", ".")
-
-	var b strings.Builder
-	b.Grow(len(s))
-	for _, r := range s {
-		if (r >= '0' && r <= '9') || r == '.' {
-			b.WriteRune(r)
-		}
-	}
-	clean := b.String()
-	if clean == "" {
-		return 0, false
-	}
-	if strings.Count(clean, ".") > 1 {
-		parts := strings.Split(clean, ".")
-		clean = strings.Join(parts[:len(parts)-1], "") + "." + parts[len(parts)-1]
-	}
-	f, err := strconv.ParseFloat(clean, 64)
-	if err != nil || f <= 0 {
-		return 0, false
+	if code := currency.Normalize(s); code != "" {
+		return code
 	}
-	return int64(f + 0.5), true
+	return currency.Detect(s)
 }
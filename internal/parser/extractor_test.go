@@ -12,14 +12,16 @@ type ExtractorSuite struct {
 }
 
 func (s *ExtractorSuite) SetupTest() {
-	s.extractor = NewExtractor()
+	extractor, err := NewExtractor()
+	s.Require().NoError(err)
+	s.extractor = extractor
 }
 
 func (s *ExtractorSuite) TestExtract_Empty() {
-	price, currency, ok := s.extractor.Extract(nil)
+	res, ok := s.extractor.Extract("", nil)
 	s.False(ok)
-	s.Equal(int64(0), price)
-	s.Equal("", currency)
+	s.Equal(int64(0), res.Price())
+	s.Equal("", res.Currency)
 }
 
 func (s *ExtractorSuite) TestExtract_MetaItemprop() {
@@ -28,10 +30,11 @@ func (s *ExtractorSuite) TestExtract_MetaItemprop() {
 		<meta itemprop="price" content="12 345">
 	</head></html>`
 
-	price, currency, ok := s.extractor.Extract([]byte(html))
+	res, ok := s.extractor.Extract("", []byte(html))
 	s.True(ok)
-	s.Equal(int64(12345), price)
-	s.Equal("RUB", currency)
+	s.Equal(int64(12345), res.Price())
+	s.Equal("RUB", res.Currency)
+	s.Equal(StrategyMeta, res.Strategy)
 }
 
 func (s *ExtractorSuite) TestExtract_MetaProperty() {
@@ -40,10 +43,11 @@ func (s *ExtractorSuite) TestExtract_MetaProperty() {
 		<meta property="product:price:amount" content="999">
 	</head></html>`
 
-	price, currency, ok := s.extractor.Extract([]byte(html))
+	res, ok := s.extractor.Extract("", []byte(html))
 	s.True(ok)
-	s.Equal(int64(999), price)
-	s.Equal("RUB", currency)
+	s.Equal(int64(999), res.Price())
+	s.Equal("RUB", res.Currency)
+	s.Equal(StrategyMeta, res.Strategy)
 }
 
 func (s *ExtractorSuite) TestExtract_JSONLD_Offers() {
@@ -53,10 +57,11 @@ func (s *ExtractorSuite) TestExtract_JSONLD_Offers() {
 		</script>
 	</head></html>`
 
-	price, currency, ok := s.extractor.Extract([]byte(html))
+	res, ok := s.extractor.Extract("", []byte(html))
 	s.True(ok)
-	s.Equal(int64(19990), price)
-	s.Equal("USD", currency)
+	s.Equal(int64(19990), res.Price())
+	s.Equal("USD", res.Currency)
+	s.Equal(StrategyJSONLD, res.Strategy)
 }
 
 func (s *ExtractorSuite) TestExtract_ScriptJSON() {
@@ -64,34 +69,180 @@ func (s *ExtractorSuite) TestExtract_ScriptJSON() {
 		<script>var product = {"price":"321","currency":"EUR"};</script>
 	</head></html>`
 
-	price, currency, ok := s.extractor.Extract([]byte(html))
+	res, ok := s.extractor.Extract("", []byte(html))
 	s.True(ok)
-	s.Equal(int64(321), price)
-	s.Equal("EUR", currency)
+	s.Equal(int64(321), res.Price())
+	s.Equal("EUR", res.Currency)
+	s.Equal(StrategyScriptJSON, res.Strategy)
 }
 
 func (s *ExtractorSuite) TestExtract_TextWithCurrency() {
 	html := `usd 10000`
-	price, currency, ok := s.extractor.Extract([]byte(html))
+	res, ok := s.extractor.Extract("", []byte(html))
 	s.True(ok)
-	s.Equal(int64(10000), price)
-	s.Equal("USD", currency)
+	s.Equal(int64(10000), res.Price())
+	s.Equal("USD", res.Currency)
+	s.Equal(StrategyCurrencyText, res.Strategy)
+}
+
+func (s *ExtractorSuite) TestExtract_TextWithCurrencySymbols() {
+	cases := []struct {
+		html     string
+		price    string
+		currency string
+	}{
+		{`<div class="price">1&nbsp;990&nbsp;₽</div>`, "1990", "RUB"},
+		{`<span>Цена: 2 490 руб.</span>`, "2490", "RUB"},
+		{`<span>350 р.</span>`, "350", "RUB"},
+		{`<b>€ 19,99</b>`, "19.99", "EUR"},
+		{`<b>£24.50</b>`, "24.50", "GBP"},
+		{`<p>15 000 ₸</p>`, "15000", "KZT"},
+		{`<p>899 грн</p>`, "899", "UAH"},
+		{`<p>45,90 Br</p>`, "45.90", "BYN"},
+		{`<p>120 000 сум</p>`, "120000", "UZS"},
+		{`<p>¥3,980</p>`, "3980", "JPY"},
+		{`<script>var p = "100 $";</script><p>Размер 42, цена 5 990 ₽</p>`, "5990", "RUB"},
+	}
+	for _, c := range cases {
+		res, ok := s.extractor.Extract("", []byte(c.html))
+		s.Require().True(ok, c.html)
+		s.Equal(StrategyCurrencyText, res.Strategy, c.html)
+		s.Equal(c.price, res.Amount.String(), c.html)
+		s.Equal(c.currency, res.Currency, c.html)
+	}
+
+	// currency words that are also common words do not make a price when
+	// they come before a number
+	negative := []struct {
+		html  string
+		price string
+	}{
+		{`<p>Shower gel 250 ml</p><p>price: 4.99</p>`, "4.99"},
+		{`<p>Try 2 months free</p><p>price 1299</p>`, "1299"},
+		{`<p>Сом 1 кг</p><p>price: 990</p>`, "990"},
+		{`<p>AMD 7800</p><p>price: 25990</p>`, "25990"},
+	}
+	for _, c := range negative {
+		res, ok := s.extractor.Extract("", []byte(c.html))
+		s.Require().True(ok, c.html)
+		s.Equal(StrategyPriceRegex, res.Strategy, c.html)
+		s.Equal(c.price, res.Amount.String(), c.html)
+		s.Empty(res.Currency, c.html)
+	}
+	res, ok := s.extractor.Extract("", []byte(`<p>USD 12.50</p>`))
+	s.Require().True(ok)
+	s.Equal("USD", res.Currency)
+}
+
+func (s *ExtractorSuite) TestExtract_CurrencyValidated() {
+	html := `<html><head>
+		<meta itemprop="price" content="1 990 ₽">
+		</head></html>`
+	res, ok := s.extractor.Extract("", []byte(html))
+	s.Require().True(ok)
+	s.Equal("RUB", res.Currency)
+
+	html = `<script type="application/ld+json">{"offers":{"price":"10","priceCurrency":"XX1"}}</script>`
+	res, ok = s.extractor.Extract("", []byte(html))
+	s.Require().True(ok)
+	s.Equal("", res.Currency)
 }
 
 func (s *ExtractorSuite) TestExtract_RegexFallback() {
 	html := `<html><body>price: 54321</body></html>`
-	price, currency, ok := s.extractor.Extract([]byte(html))
+	res, ok := s.extractor.Extract("", []byte(html))
 	s.True(ok)
-	s.Equal(int64(54321), price)
-	s.Equal("", currency)
+	s.Equal(int64(54321), res.Price())
+	s.Equal("", res.Currency)
+	s.Equal(StrategyPriceRegex, res.Strategy)
+	s.Equal("54321", res.Raw)
+	s.Equal(ConfidenceLow, res.Confidence)
 }
 
 func (s *ExtractorSuite) TestExtract_NoMatches() {
 	html := `<html><body>nothing here</body></html>`
-	price, currency, ok := s.extractor.Extract([]byte(html))
+	res, ok := s.extractor.Extract("", []byte(html))
 	s.False(ok)
-	s.Equal(int64(0), price)
-	s.Equal("", currency)
+	s.Equal(int64(0), res.Price())
+	s.Equal("", res.Currency)
+}
+
+func (s *ExtractorSuite) TestExtract_RuleBeforeHeuristics() {
+	extractor, err := NewExtractor(Rule{
+		Host:     "shop.example",
+		Price:    FieldRule{Selector: ".product .price-current", Regex: `([0-9][0-9\s]*)`},
+		Currency: FieldRule{Value: "RUB"},
+		OldPrice: FieldRule{Selector: ".product .price-old"},
+	})
+	s.Require().NoError(err)
+
+	html := `<html><head><meta itemprop="price" content="1"></head><body>
+		<div class="product">
+			<span class="price-old">2 990</span>
+			<span class="price-current">Цена: <b>1 990</b> руб.</span>
+		</div>
+	</body></html>`
+
+	res, ok := extractor.Extract("https://www.shop.example/item/1", []byte(html))
+	s.True(ok)
+	s.Equal(int64(1990), res.Price())
+	s.Equal("RUB", res.Currency)
+	s.Equal(StrategyRule, res.Strategy)
+	s.Equal("shop.example", res.Rule)
+	s.Equal("1 990", res.Raw)
+	s.Equal(ConfidenceHigh, res.Confidence)
+	s.Equal(Decimal{Value: 2990}, res.OldPrice)
+
+	res, ok = extractor.Extract("https://other.example/item/1", []byte(html))
+	s.True(ok)
+	s.Equal(int64(1), res.Price())
+	s.Equal("", res.Currency)
+	s.Equal(StrategyMeta, res.Strategy)
+}
+
+func (s *ExtractorSuite) TestExtract_RuleURLPatternAndAttr() {
+	extractor, err := NewExtractor(Rule{
+		Name:       "cdn shop",
+		URLPattern: `^https://cdn\.example/p/`,
+		Price:      FieldRule{Selector: "[data-price]", Attr: "data-price"},
+		Currency:   FieldRule{Selector: "[data-currency]", Attr: "data-currency"},
+	})
+	s.Require().NoError(err)
+
+	html := `<div data-price="450" data-currency="usd"></div>`
+	res, ok := extractor.Extract("https://cdn.example/p/42", []byte(html))
+	s.True(ok)
+	s.Equal(int64(450), res.Price())
+	s.Equal("USD", res.Currency)
+	s.Equal(StrategyRule, res.Strategy)
+}
+
+func (s *ExtractorSuite) TestExtract_RuleMissFallsBack() {
+	extractor, err := NewExtractor(Rule{
+		Host:  "shop.example",
+		Price: FieldRule{Selector: ".absent"},
+	})
+	s.Require().NoError(err)
+
+	res, ok := extractor.Extract("https://shop.example/", []byte(`usd 10000`))
+	s.True(ok)
+	s.Equal(int64(10000), res.Price())
+	s.Equal("USD", res.Currency)
+	s.Equal(StrategyCurrencyText, res.Strategy)
+}
+
+func (s *ExtractorSuite) TestNewExtractor_InvalidRules() {
+	_, err := NewExtractor(Rule{Price: FieldRule{Selector: ".price"}})
+	s.Error(err)
+
+	_, err = NewExtractor(Rule{Host: "shop.example"})
+	s.Error(err)
+
+	_, err = NewExtractor(Rule{Host: "shop.example", Price: FieldRule{Selector: "[["}})
+	s.Error(err)
+
+	_, err = NewExtractor(Rule{Host: "shop.example", Price: FieldRule{Selector: ".price", Regex: "("}})
+	s.Error(err)
 }
 
 func (s *ExtractorSuite) TestExtractFromMeta_CurrencyOnly() {
@@ -251,38 +402,342 @@ func (s *ExtractorSuite) TestFirstKeyToStringNormalizeAndParse() {
 	s.Equal("RUB", normalizeCurrency("RUR"))
 	s.Equal("RUB", normalizeCurrency("rub"))
 	s.Equal("USD", normalizeCurrency("usd"))
+}
 
-	price, ok := parsePriceInt64(" 1 234,56 ")
-	s.True(ok)
-	s.Equal(int64(1235), price)
+func (s *ExtractorSuite) TestParseNumber() {
+	ru := numberHints{lang: "ru"}
+	en := numberHints{lang: "en-US"}
+	cases := []struct {
+		in    string
+		hints numberHints
+		want  string
+		err   error
+	}{
+		{" 1 234,56 ", numberHints{}, "1234.56", nil},
+		{"1\u00a0234,56\u00a0₽", numberHints{}, "1234.56", nil},
+		{"1\u202f299,90", numberHints{}, "1299.90", nil},
+		{"1\u2009299", numberHints{}, "1299", nil},
+		{"1'234.50", numberHints{}, "1234.50", nil},
+		{"RUB 2 000", numberHints{}, "2000", nil},
+		{"1,299.00", numberHints{}, "1299.00", nil},
+		{"1.299,00", numberHints{}, "1299.00", nil},
+		{"1.234.567", numberHints{}, "1234567", nil},
+		{"99.90", numberHints{}, "99.90", nil},
+		{"0.299", numberHints{}, "0.299", nil},
+		{"1299.000", numberHints{}, "1299.000", nil},
+		{"100", numberHints{}, "100", nil},
+		{"от 1 990 до 2 490", numberHints{}, "1990", nil},
+
+		// a single separator before three digits needs a hint
+		{"1.299", numberHints{}, "", errAmbiguousNumber},
+		{"1,299", numberHints{}, "", errAmbiguousNumber},
+		{"1.299", ru, "1299", nil},
+		{"1,299", ru, "1.299", nil},
+		{"1,299", en, "1299", nil},
+		{"1.299", en, "1.299", nil},
+		{"1.299", numberHints{lang: "de-CH"}, "1.299", nil},
+		{"1.299", numberHints{tld: "de"}, "1299", nil},
+		{"1.299", numberHints{currency: "RUB"}, "1299", nil},
+		{"1.299", numberHints{lang: "en", currency: "KWD"}, "1.299", nil},
+
+		// machine-readable values only use '.' as the decimal separator
+		{"1.299", numberHints{tld: "de", currency: "EUR", machine: true}, "1.299", nil},
+		{"12.345", numberHints{currency: "USD", machine: true}, "12.345", nil},
+		{"1,299.00", numberHints{machine: true}, "1299.00", nil},
+		{"1 990", numberHints{machine: true}, "1990", nil},
+		{"1,299", numberHints{currency: "RUB", machine: true}, "1299", nil},
+		{"1.234.567", numberHints{machine: true}, "", errMalformedNumber},
+		{"1.299,00", numberHints{machine: true}, "", errMalformedNumber},
+
+		{"1.234.56", numberHints{}, "", errMalformedNumber},
+		{"12 34", numberHints{}, "", errMalformedNumber},
+		{"1.299,00,00", numberHints{}, "", errMalformedNumber},
+		{"abc", numberHints{}, "", errMalformedNumber},
+		{"", numberHints{}, "", errMalformedNumber},
+	}
+	for _, c := range cases {
+		got, err := parseNumber(c.in, c.hints)
+		if c.err != nil {
+			s.ErrorIs(err, c.err, c.in)
+			continue
+		}
+		s.Require().NoError(err, c.in)
+		s.Equal(c.want, got.String(), c.in)
+	}
+}
 
-	price, ok = parsePriceInt64("1.234.56")
-	s.True(ok)
-	s.Equal(int64(1235), price)
+func (s *ExtractorSuite) TestPageHints() {
+	h := pageHints("https://shop.example.de/item", []byte(`<!DOCTYPE html><html lang="de-DE"><head></head></html>`))
+	s.Equal(numberHints{lang: "de-DE", tld: "de"}, h)
 
-	price, ok = parsePriceInt64("RUB 2 000")
-	s.True(ok)
-	s.Equal(int64(2000), price)
+	h = pageHints("https://shop.example.com/item", []byte(`<div>no html tag</div>`))
+	s.Equal(numberHints{tld: "com"}, h)
+}
 
-	price, ok = parsePriceInt64("0")
-	s.False(ok)
-	s.Equal(int64(0), price)
+func (s *ExtractorSuite) TestExtract_AmbiguousPriceIsSkipped() {
+	html := []byte(`<html><head><script>window.__STATE__ = {"product":{"price":"1.299"}};</script></head>` +
+		`<body><span>price: 1 299 RUB</span></body></html>`)
 
-	price, ok = parsePriceInt64("abc")
-	s.False(ok)
-	s.Equal(int64(0), price)
+	res, ok := s.extractor.Extract("https://shop.example.com/item", html)
 
-	price, ok = parsePriceInt64(".")
-	s.False(ok)
-	s.Equal(int64(0), price)
+	s.Require().True(ok)
+	s.Equal(StrategyCurrencyText, res.Strategy)
+	s.Equal(int64(1299), res.Price())
+}
+
+func (s *ExtractorSuite) TestExtract_MachineReadablePrice() {
+	cases := []struct {
+		name     string
+		url      string
+		html     string
+		strategy Strategy
+		want     Decimal
+	}{
+		{
+			name:     "json-ld number",
+			url:      "https://shop.example.de/item",
+			html:     `<script type="application/ld+json">{"@type":"Offer","price":1.299,"priceCurrency":"EUR"}</script>`,
+			strategy: StrategyJSONLD,
+			want:     Decimal{Value: 1299, Scale: 3},
+		},
+		{
+			name:     "json-ld number in dollars",
+			url:      "https://shop.example.com/item",
+			html:     `<script type="application/ld+json">{"@type":"Offer","price":12.345,"priceCurrency":"USD"}</script>`,
+			strategy: StrategyJSONLD,
+			want:     Decimal{Value: 12345, Scale: 3},
+		},
+		{
+			name:     "json-ld string on a comma tld",
+			url:      "https://shop.example.de/item",
+			html:     `<script type="application/ld+json">{"@type":"Offer","price":"1.299","priceCurrency":"KWD"}</script>`,
+			strategy: StrategyJSONLD,
+			want:     Decimal{Value: 1299, Scale: 3},
+		},
+		{
+			name:     "itemprop content",
+			url:      "https://shop.example.de/item",
+			html:     `<meta itemprop="priceCurrency" content="EUR"><meta itemprop="price" content="1.299">`,
+			strategy: StrategyMeta,
+			want:     Decimal{Value: 1299, Scale: 3},
+		},
+		{
+			name:     "embedded json number",
+			url:      "https://shop.example.de/item",
+			html:     `<script>window.__STATE__ = {"product":{"price":1.299,"currency":"EUR"}};</script>`,
+			strategy: StrategyScriptJSON,
+			want:     Decimal{Value: 1299, Scale: 3},
+		},
+		{
+			name:     "embedded json string",
+			url:      "https://shop.example.de/item",
+			html:     `<script>window.__STATE__ = {"product":{"price":"1.299","currency":"EUR"}};</script>`,
+			strategy: StrategyScriptJSON,
+			want:     Decimal{Value: 1299},
+		},
+		{
+			name:     "text",
+			url:      "https://shop.example.de/item",
+			html:     `<div>1.299 €</div>`,
+			strategy: StrategyCurrencyText,
+			want:     Decimal{Value: 1299},
+		},
+	}
+	for _, tc := range cases {
+		res, ok := s.extractor.Extract(tc.url, []byte(`<html><body>`+tc.html+`</body></html>`))
+		s.Require().True(ok, tc.name)
+		s.Equal(tc.strategy, res.Strategy, tc.name)
+		s.Equal(tc.want, res.Amount, tc.name)
+	}
+
+	// so are the old prices of the offer
+	res, ok := s.extractor.Extract("https://shop.example.de/item", []byte(`<html><body><script type="application/ld+json">`+
+		`{"@type":"Offer","price":1.099,"priceCurrency":"EUR","oldPrice":1.299}</script></body></html>`))
+	s.Require().True(ok)
+	s.Equal(Decimal{Value: 1099, Scale: 3}, res.Amount)
+	s.Equal(Decimal{Value: 1299, Scale: 3}, res.OldPrice)
+}
+
+func (s *ExtractorSuite) TestExtract_OldPrice() {
+	cases := []struct {
+		name string
+		html string
+		want Decimal
+	}{
+		{
+			name: "json-ld list price",
+			html: `<script type="application/ld+json">{"@type":"Product","offers":{"@type":"Offer","price":"990","priceCurrency":"RUB",
+				"priceSpecification":[{"@type":"UnitPriceSpecification","priceType":"https://schema.org/ListPrice","price":"1490.50"}]}}</script>`,
+			want: Decimal{Value: 149050, Scale: 2},
+		},
+		{
+			name: "json-ld high price",
+			html: `<script type="application/ld+json">{"offers":{"@type":"AggregateOffer","lowPrice":"990","highPrice":"1290","priceCurrency":"RUB"}}</script>`,
+			want: Decimal{Value: 1290},
+		},
+		{
+			name: "embedded json key",
+			html: `<script>window.__STATE__ = {"product":{"price":990,"price_old":1190}};</script>`,
+			want: Decimal{Value: 1190},
+		},
+		{
+			name: "strikethrough",
+			html: `<div itemscope><meta itemprop="price" content="990"><del>1 490 ₽</del> <b>990 ₽</b></div>`,
+			want: Decimal{Value: 1490},
+		},
+		{
+			name: "old price class",
+			html: `<div class="price"><span class="product__price-old">1 190 ₽</span><meta itemprop="price" content="990"></div>`,
+			want: Decimal{Value: 1190},
+		},
+		{
+			name: "strikethrough near text price",
+			html: `<h1>Чайник</h1><div class="price"><span><b>990</b> ₽</span> <s>1 290 ₽</s></div>`,
+			want: Decimal{Value: 1290},
+		},
+		{
+			name: "del before text price",
+			html: `<h1>Чайник</h1><div class="price"><del>1 490 ₽</del> <span><b>990</b> ₽</span></div>`,
+			want: Decimal{Value: 1490},
+		},
+		{
+			name: "s before text price",
+			html: `<div class="price"><s>1 290 ₽</s> 990 ₽</div>`,
+			want: Decimal{Value: 1290},
+		},
+		{
+			name: "old price class before text price",
+			html: `<div class="price"><span class="price-old"><span>1 190</span> ₽</span><br><span class="price-new">990 ₽</span></div>`,
+			want: Decimal{Value: 1190},
+		},
+		{
+			name: "not above price",
+			html: `<div><meta itemprop="price" content="990"><del>990 ₽</del><s>500 ₽</s></div>`,
+		},
+		{
+			name: "too far above price",
+			html: `<div><meta itemprop="price" content="990"><del>99 000 ₽</del></div>`,
+		},
+		{
+			name: "struck price of another product",
+			html: `<div class="card"><meta itemprop="price" content="990"><b>990 ₽</b></div>
+				<ul class="related"><li><del>1 490 ₽</del> 1 190 ₽</li></ul>`,
+		},
+		{
+			name: "old price of another offer",
+			html: `<script type="application/ld+json">{"@type":"Product","offers":{"price":"990","priceCurrency":"RUB"},
+				"isRelatedTo":{"@type":"Product","offers":{"price":"1190","priceCurrency":"RUB","oldPrice":"1490"}}}</script>`,
+		},
+	}
+	for _, tc := range cases {
+		res, ok := s.extractor.Extract("https://shop.example.ru/item", []byte(`<html><body>`+tc.html+`</body></html>`))
+		s.Require().True(ok, tc.name)
+		s.Equal(Decimal{Value: 990}, res.Amount, tc.name)
+		s.Equal(tc.want, res.OldPrice, tc.name)
+	}
+}
 
-	price, ok = parsePriceInt64("100")
+func (s *ExtractorSuite) TestExtract_Availability() {
+	cases := []struct {
+		name string
+		html string
+		want Availability
+	}{
+		{
+			name: "json-ld offer",
+			html: `<script type="application/ld+json">{"@type":"Product","offers":{"price":"990","priceCurrency":"RUB","availability":"https://schema.org/OutOfStock"}}</script>`,
+			want: AvailabilityOutOfStock,
+		},
+		{
+			name: "json-ld discontinued",
+			html: `<script type="application/ld+json">{"offers":[{"price":"990","priceCurrency":"RUB","availability":"http://schema.org/Discontinued"}]}</script>`,
+			want: AvailabilityDiscontinued,
+		},
+		{
+			name: "itemprop link",
+			html: `<div itemscope><meta itemprop="price" content="990"><link itemprop="availability" href="https://schema.org/PreOrder"></div>`,
+			want: AvailabilityPreOrder,
+		},
+		{
+			name: "og availability",
+			html: `<meta property="og:availability" content="instock"><meta itemprop="price" content="990">`,
+			want: AvailabilityInStock,
+		},
+		{
+			name: "embedded json flag",
+			html: `<script>window.__STATE__ = {"product":{"price":990,"inStock":false}};</script>`,
+			want: AvailabilityOutOfStock,
+		},
+		{
+			name: "embedded json quantity",
+			html: `<script>window.__STATE__ = {"product":{"price":990,"stock_quantity":3}};</script>`,
+			want: AvailabilityInStock,
+		},
+		{
+			name: "text marker",
+			html: `<div class="product"><meta itemprop="price" content="990"><div class="stock">Нет в наличии</div></div>`,
+			want: AvailabilityOutOfStock,
+		},
+		{
+			name: "markup before text",
+			html: `<div itemscope><meta itemprop="price" content="990"><meta itemprop="availability" content="InStock">
+				<a>Скрыть товары, которых нет в наличии</a></div>`,
+			want: AvailabilityInStock,
+		},
+		{
+			name: "unknown",
+			html: `<div><meta itemprop="price" content="990"><div>В наличии в 3 магазинах</div></div>`,
+			want: AvailabilityUnknown,
+		},
+		{
+			name: "flag of another object",
+			html: `<script>window.__STATE__ = {"delivery":{"available":false},"product":{"price":990}};</script>`,
+			want: AvailabilityUnknown,
+		},
+		{
+			name: "availability of another offer",
+			html: `<script type="application/ld+json">{"@type":"Product","offers":{"price":"990","priceCurrency":"RUB"},
+				"isRelatedTo":{"@type":"Product","offers":{"price":"1190","availability":"https://schema.org/OutOfStock"}}}</script>`,
+			want: AvailabilityUnknown,
+		},
+		{
+			name: "filter label outside the price block",
+			html: `<aside><label><input type="checkbox"> Нет в наличии</label></aside>
+				<div class="card"><meta itemprop="price" content="990"><b>990 ₽</b></div>`,
+			want: AvailabilityUnknown,
+		},
+		{
+			name: "product of the offer",
+			html: `<script type="application/ld+json">{"@type":"Product","availability":"https://schema.org/PreOrder","offers":{"price":"990","priceCurrency":"RUB"}}</script>`,
+			want: AvailabilityPreOrder,
+		},
+	}
+	for _, tc := range cases {
+		res, ok := s.extractor.Extract("https://shop.example.ru/item", []byte(`<html><body>`+tc.html+`</body></html>`))
+		s.Require().True(ok, tc.name)
+		s.Equal(int64(990), res.Price(), tc.name)
+		s.Equal(tc.want, res.Availability, tc.name)
+	}
+}
+
+func (s *ExtractorSuite) TestDecimal() {
+	d, ok := parseDecimal("1234.5")
+	s.Require().True(ok)
+
+	minor, ok := d.Rescale(2)
 	s.True(ok)
-	s.Equal(int64(100), price)
+	s.Equal(int64(123450), minor)
+	s.Equal("1234.50", d.Format(2))
+	s.Equal("1235", d.Format(0))
+	s.Equal("1234.500", d.Format(3))
+
+	d, _ = parseDecimal("0.045")
+	s.Equal("0.05", d.Format(2))
+	s.Equal("0.04", Decimal{Value: 449, Scale: 4}.Format(2))
+	s.Equal(Decimal{Value: 999, Scale: 1}, Decimal{Value: 99900, Scale: 3}.Normalize())
 
-	price, ok = parsePriceInt64("")
+	_, ok = parseDecimal("12345678901234567890")
 	s.False(ok)
-	s.Equal(int64(0), price)
 }
 
 func TestExtractorSuite(t *testing.T) {
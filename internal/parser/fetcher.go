@@ -7,26 +7,65 @@ import (
 	"io"
 	"math/rand/v2"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
+
+	"github.com/LehaAlexey/Parsing/internal/metrics"
+)
+
+// TruncatePolicy decides what happens to a body larger than the limit.
+type TruncatePolicy string
+
+const (
+	// TruncateFail returns a *BodyTooLargeError.
+	TruncateFail TruncatePolicy = "fail"
+	// TruncateContinue, the default, keeps the first MaxBodyBytes and sets
+	// FetchResult.Truncated.
+	TruncateContinue TruncatePolicy = "continue"
 )
 
 type FetcherConfig struct {
-	UserAgent            string
-	RequestTimeout       time.Duration
-	MaxBodyBytes         int64
+	UserAgent      string
+	RequestTimeout time.Duration
+	MaxBodyBytes   int64
+	// MaxBodyBytesByHost overrides MaxBodyBytes for a host and its subdomains.
+	MaxBodyBytesByHost   map[string]int64
+	TruncatePolicy       TruncatePolicy
 	Retries              int
 	MinBackoff           time.Duration
 	MaxBackoff           time.Duration
 	PerDomainMinInterval time.Duration
+	// PerDomainMaxInterval caps how far the interval of a throttling host
+	// grows; PerDomainRecoveryStep is how much it shrinks after a success.
+	PerDomainMaxInterval  time.Duration
+	PerDomainRecoveryStep time.Duration
+	// MaxRetryAfter caps the Retry-After hint; a failure asking to wait longer
+	// is not retried.
+	MaxRetryAfter time.Duration
+	// RespectRobots makes the fetcher consult robots.txt (cached for RobotsTTL)
+	// and honour its Crawl-delay.
+	RespectRobots bool
+	RobotsTTL     time.Duration
+	// The circuit of a host opens after BreakerFailureThreshold consecutive
+	// network errors or 5xx, fails fast for BreakerCoolDown and then lets
+	// BreakerHalfOpenRequests probes through.
+	BreakerFailureThreshold int
+	BreakerCoolDown         time.Duration
+	BreakerHalfOpenRequests int
+	// AllowPrivateNetworks lets the fetcher connect to loopback, private and
+	// link-local addresses. Off in production: URLs come from users.
+	AllowPrivateNetworks bool
 }
 
 type Fetcher struct {
-	cfg       FetcherConfig
-	client    *http.Client
-	limiter   *domainLimiter
+	cfg     FetcherConfig
+	client  *http.Client
+	limiter *domainLimiter
+	breaker *circuitBreaker
+	robots  *robotsCache
 }
 
 func NewFetcher(cfg FetcherConfig) *Fetcher {
@@ -39,6 +78,14 @@ func NewFetcher(cfg FetcherConfig) *Fetcher {
 	if cfg.MaxBodyBytes <= 0 {
 		cfg.MaxBodyBytes = 5 * 1024 * 1024
 	}
+	if cfg.TruncatePolicy == "" {
+		cfg.TruncatePolicy = TruncateContinue
+	}
+	limits := make(map[string]int64, len(cfg.MaxBodyBytesByHost))
+	for host, limit := range cfg.MaxBodyBytesByHost {
+		limits[normalizeHost(host)] = limit
+	}
+	cfg.MaxBodyBytesByHost = limits
 	if cfg.Retries <= 0 {
 		cfg.Retries = 3
 	}
@@ -51,76 +98,169 @@ func NewFetcher(cfg FetcherConfig) *Fetcher {
 	if cfg.PerDomainMinInterval <= 0 {
 		cfg.PerDomainMinInterval = 300 * time.Millisecond
 	}
+	if cfg.PerDomainMaxInterval <= 0 {
+		cfg.PerDomainMaxInterval = time.Minute
+	}
+	if cfg.PerDomainRecoveryStep <= 0 {
+		cfg.PerDomainRecoveryStep = 100 * time.Millisecond
+	}
+	if cfg.MaxRetryAfter <= 0 {
+		cfg.MaxRetryAfter = time.Minute
+	}
+	if cfg.BreakerFailureThreshold <= 0 {
+		cfg.BreakerFailureThreshold = 5
+	}
+	if cfg.BreakerCoolDown <= 0 {
+		cfg.BreakerCoolDown = 30 * time.Second
+	}
+	if cfg.BreakerHalfOpenRequests <= 0 {
+		cfg.BreakerHalfOpenRequests = 1
+	}
+	if cfg.RobotsTTL <= 0 {
+		cfg.RobotsTTL = time.Hour
+	}
 
-	return &Fetcher{
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if !cfg.AllowPrivateNetworks {
+		transport.DialContext = guardedDialer()
+	}
+
+	f := &Fetcher{
 		cfg: cfg,
 		client: &http.Client{
-			Timeout: cfg.RequestTimeout,
-			Transport: &http.Transport{
-				Proxy: http.ProxyFromEnvironment,
-				MaxIdleConns:          100,
-				IdleConnTimeout:       90 * time.Second,
-				TLSHandshakeTimeout:   10 * time.Second,
-				ExpectContinueTimeout: 1 * time.Second,
-			},
+			Timeout:   cfg.RequestTimeout,
+			Transport: transport,
 		},
-		limiter: newDomainLimiter(cfg.PerDomainMinInterval),
+		limiter: newDomainLimiter(cfg.PerDomainMinInterval, cfg.PerDomainMaxInterval, cfg.PerDomainRecoveryStep),
+		breaker: newCircuitBreaker(cfg.BreakerFailureThreshold, cfg.BreakerCoolDown, cfg.BreakerHalfOpenRequests),
+	}
+	if cfg.RespectRobots {
+		f.robots = newRobotsCache(cfg.RobotsTTL, cfg.UserAgent, f.client, f.limiter, f.breaker)
 	}
+	return f
 }
 
-func (f *Fetcher) Fetch(ctx context.Context, rawURL string) ([]byte, string, error) {
+// Fetch downloads rawURL with retries. Errors are wrapped in *FetchError once
+// the first request was attempted.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*FetchResult, error) {
 	rawURL = strings.TrimSpace(rawURL)
 	if rawURL == "" {
-		return nil, "", fmt.Errorf("empty url")
+		return nil, fmt.Errorf("empty url")
 	}
 
 	u, err := url.Parse(rawURL)
 	if err != nil {
-		return nil, "", fmt.Errorf("invalid url: %w", err)
+		return nil, fmt.Errorf("invalid url: %w", err)
 	}
 	if u.Scheme == "" {
 		u.Scheme = "https"
 	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
 	u.Fragment = ""
 
 	host := strings.ToLower(u.Hostname())
 	if host == "" {
-		return nil, "", fmt.Errorf("invalid url host")
+		return nil, fmt.Errorf("invalid url host")
+	}
+	if !f.cfg.AllowPrivateNetworks {
+		if err := CheckTarget(u.String()); err != nil {
+			metrics.FetchErrors.WithLabelValues(host, errorKind(err)).Inc()
+			return nil, &FetchError{Err: err}
+		}
+	}
+
+	if f.robots != nil {
+		if err := f.robots.Check(ctx, host, u); err != nil {
+			metrics.FetchErrors.WithLabelValues(host, errorKind(err)).Inc()
+			return nil, &FetchError{Err: err}
+		}
 	}
 
 	var lastErr error
+	attempts := 0
 	for attempt := 0; attempt <= f.cfg.Retries; attempt++ {
+		if err := f.breaker.Allow(host); err != nil {
+			metrics.FetchErrors.WithLabelValues(host, errorKind(err)).Inc()
+			// when our own failures opened the circuit, report them instead
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+		attempts++
+		waitStart := time.Now()
 		if err := f.limiter.Wait(ctx, host); err != nil {
-			return nil, "", err
+			f.breaker.Record(host, err)
+			return nil, err
 		}
+		wait := time.Since(waitStart)
+		metrics.LimiterWait.WithLabelValues(host).Observe(wait.Seconds())
 
-		body, finalURL, err := f.fetchOnce(ctx, u.String())
+		res, err := f.fetchOnce(ctx, host, u.String())
+		if err != nil && ctx.Err() != nil {
+			f.breaker.Record(host, ctx.Err())
+		} else {
+			f.breaker.Record(host, err)
+		}
+		hint := retryAfter(err)
+		f.limiter.Feedback(host, err, min(hint, f.cfg.MaxRetryAfter))
 		if err == nil {
-			return body, finalURL, nil
+			res.URL = u.String()
+			res.Attempts = attempts
+			res.Timings.Wait = wait
+			return res, nil
 		}
 
 		lastErr = err
-		if attempt == f.cfg.Retries {
+		metrics.FetchErrors.WithLabelValues(host, errorKind(err)).Inc()
+		if attempt == f.cfg.Retries || !IsRetryable(err) || hint > f.cfg.MaxRetryAfter {
 			break
 		}
 
+		// a Retry-After pause is enforced by the limiter on the next Wait
+		metrics.FetchRetries.WithLabelValues(host).Inc()
 		sleep := backoff(attempt, f.cfg.MinBackoff, f.cfg.MaxBackoff)
 		timer := time.NewTimer(sleep)
 		select {
 		case <-ctx.Done():
 			timer.Stop()
-			return nil, "", ctx.Err()
+			return nil, ctx.Err()
 		case <-timer.C:
 		}
 	}
 
-	return nil, "", lastErr
+	return nil, &FetchError{Attempts: attempts, Err: lastErr}
 }
 
-func (f *Fetcher) fetchOnce(ctx context.Context, url string) ([]byte, string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// Domains returns the current per-host rate limits.
+func (f *Fetcher) Domains() []DomainState {
+	return f.limiter.Domains()
+}
+
+// Breakers returns the hosts whose circuit is open, half-open or has recent
+// failures.
+func (f *Fetcher) Breakers() []BreakerStatus {
+	return f.breaker.Status()
+}
+
+func (f *Fetcher) fetchOnce(ctx context.Context, host, url string) (*FetchResult, error) {
+	trace := newFetchTrace()
+	defer func() {
+		metrics.FetchDuration.WithLabelValues(host).Observe(time.Since(trace.start).Seconds())
+	}()
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace.clientTrace()), http.MethodGet, url, nil)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 	req.Header.Set("User-Agent", f.cfg.UserAgent)
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
@@ -128,77 +268,145 @@ func (f *Fetcher) fetchOnce(ctx context.Context, url string) ([]byte, string, er
 
 	resp, err := f.client.Do(req)
 	if err != nil {
-		return nil, "", err
+		metrics.FetchResponses.WithLabelValues(host, "error").Inc()
+		return nil, classifyNetworkError(err)
 	}
 	defer resp.Body.Close()
+	metrics.FetchResponses.WithLabelValues(host, strconv.Itoa(resp.StatusCode)).Inc()
+
+	finalURL := ""
+	if resp.Request != nil && resp.Request.URL != nil {
+		finalURL = resp.Request.URL.String()
+	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, "", fmt.Errorf("http status %d", resp.StatusCode)
+		// a short prefix is enough to recognise a challenge page
+		head, _ := io.ReadAll(io.LimitReader(resp.Body, blockSniffBytes))
+		if reason, ok := detectBlock(resp, finalURL, head); ok {
+			return nil, &BlockedError{StatusCode: resp.StatusCode, Header: resp.Header, Reason: reason}
+		}
+		return nil, &HTTPStatusError{StatusCode: resp.StatusCode, Header: resp.Header}
+	}
+	if reason, ok := detectBlock(resp, finalURL, nil); ok {
+		return nil, &BlockedError{StatusCode: resp.StatusCode, Header: resp.Header, Reason: reason}
+	}
+	limit := f.bodyLimit(host)
+	if resp.ContentLength > limit && f.cfg.TruncatePolicy == TruncateFail {
+		return nil, &BodyTooLargeError{Size: resp.ContentLength, Limit: limit}
 	}
 
-	limited := io.LimitReader(resp.Body, f.cfg.MaxBodyBytes)
-	b, err := io.ReadAll(limited)
+	// one byte over the limit tells a truncated body from one of exactly
+	// limit bytes
+	b, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
 	if err != nil {
-		return nil, "", err
+		return nil, classifyNetworkError(err)
 	}
-
-	finalURL := ""
-	if resp.Request != nil && resp.Request.URL != nil {
-		finalURL = resp.Request.URL.String()
+	truncated := int64(len(b)) > limit
+	if truncated {
+		if f.cfg.TruncatePolicy == TruncateFail {
+			return nil, &BodyTooLargeError{Size: max(resp.ContentLength, 0), Limit: limit}
+		}
+		b = trimPartialRune(b[:limit])
+		metrics.FetchTruncated.WithLabelValues(host).Inc()
 	}
-	return bytes.Clone(b), finalURL, nil
+
+	contentType := resp.Header.Get("Content-Type")
+	body, cs := toUTF8(b, contentType)
+	metrics.FetchCharsets.WithLabelValues(cs).Inc()
+
+	timings := trace.done()
+	observePhases(host, timings)
+	return &FetchResult{
+		Body:        body,
+		FinalURL:    finalURL,
+		StatusCode:  resp.StatusCode,
+		Header:      resp.Header,
+		ContentType: contentType,
+		Charset:     cs,
+		Redirects:   redirectChain(resp),
+		Truncated:   truncated,
+		Timings:     timings,
+	}, nil
 }
 
-func backoff(attempt int, min, max time.Duration) time.Duration {
-	if attempt < 0 {
-		attempt = 0
-	}
-	sleep := min * time.Duration(1<<attempt)
-	if sleep > max {
-		sleep = max
+// bodyLimit returns the body limit of host: the override of the closest
+// matching domain, or MaxBodyBytes.
+func (f *Fetcher) bodyLimit(host string) int64 {
+	for h := host; h != ""; {
+		if limit, ok := f.cfg.MaxBodyBytesByHost[h]; ok && limit > 0 {
+			return limit
+		}
+		_, h, _ = strings.Cut(h, ".")
 	}
-	jitter := time.Duration(rand.IntN(120)) * time.Millisecond
-	return sleep + jitter
+	return f.cfg.MaxBodyBytes
 }
 
-type domainLimiter struct {
-	minInterval time.Duration
-	mu          sync.Mutex
-	last        map[string]time.Time
+func observePhases(host string, t FetchTimings) {
+	for _, p := range []struct {
+		phase string
+		d     time.Duration
+	}{
+		{"dns", t.DNS},
+		{"connect", t.Connect},
+		{"tls", t.TLS},
+		{"ttfb", t.TTFB},
+	} {
+		if p.d > 0 {
+			metrics.FetchPhaseDuration.WithLabelValues(host, p.phase).Observe(p.d.Seconds())
+		}
+	}
 }
 
-func newDomainLimiter(minInterval time.Duration) *domainLimiter {
-	return &domainLimiter{
-		minInterval: minInterval,
-		last:        make(map[string]time.Time),
+func isCaptchaPath(p string) bool {
+	for _, seg := range strings.Split(strings.ToLower(p), "/") {
+		if seg == "captcha" || seg == "showcaptcha" || strings.HasPrefix(seg, "captcha.") {
+			return true
+		}
 	}
+	return false
+}
+
+const blockSniffBytes = 64 << 10
+
+var blockMarkers = []struct {
+	marker string
+	reason string
+}{
+	{"cf-chl-", "cloudflare challenge"},
+	{"Just a moment...", "cloudflare challenge"},
+	{"DDoS-Guard", "ddos-guard challenge"},
+	{"Checking your browser", "browser check"},
+	{"smartcaptcha", "captcha"},
+	{"g-recaptcha", "captcha"},
+	{"hcaptcha", "captcha"},
 }
 
-func (l *domainLimiter) Wait(ctx context.Context, host string) error {
-	l.mu.Lock()
-	last, ok := l.last[host]
-	now := time.Now()
-	var wait time.Duration
-	if ok {
-		elapsed := now.Sub(last)
-		if elapsed < l.minInterval {
-			wait = l.minInterval - elapsed
+// detectBlock recognises anti-bot responses: a challenge header, a redirect to
+// a captcha page, or a challenge page body. body is only inspected for
+// non-2xx responses, where a product page is not expected anyway.
+func detectBlock(resp *http.Response, finalURL string, body []byte) (string, bool) {
+	if strings.EqualFold(resp.Header.Get("cf-mitigated"), "challenge") {
+		return "cloudflare challenge", true
+	}
+	if u, err := url.Parse(finalURL); err == nil && isCaptchaPath(u.Path) {
+		return "redirected to captcha", true
+	}
+	for _, m := range blockMarkers {
+		if bytes.Contains(body, []byte(m.marker)) {
+			return m.reason, true
 		}
 	}
-	l.last[host] = now.Add(wait)
-	l.mu.Unlock()
+	return "", false
+}
 
-	if wait <= 0 {
-		return nil
+func backoff(attempt int, min, max time.Duration) time.Duration {
+	if attempt < 0 {
+		attempt = 0
 	}
-
-	timer := time.NewTimer(wait)
-	defer timer.Stop()
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-timer.C:
-		return nil
+	sleep := min * time.Duration(1<<attempt)
+	if sleep > max {
+		sleep = max
 	}
+	jitter := time.Duration(rand.IntN(120)) * time.Millisecond
+	return sleep + jitter
 }
-
@@ -0,0 +1,503 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/text/encoding/charmap"
+)
+
+type FetcherSuite struct {
+	suite.Suite
+	fetcher *Fetcher
+	hits    atomic.Int32
+}
+
+func (s *FetcherSuite) SetupTest() {
+	s.hits.Store(0)
+	s.fetcher = NewFetcher(FetcherConfig{
+		Retries:              2,
+		MinBackoff:           time.Millisecond,
+		MaxBackoff:           time.Millisecond,
+		PerDomainMinInterval: time.Millisecond,
+		MaxBodyBytes:         1024,
+		AllowPrivateNetworks: true,
+	})
+}
+
+func (s *FetcherSuite) serve(h http.HandlerFunc) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits.Add(1)
+		h(w, r)
+	}))
+	s.T().Cleanup(srv.Close)
+	return srv.URL
+}
+
+func (s *FetcherSuite) TestFetch_NotFoundIsNotRetried() {
+	u := s.serve(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Shop", "1")
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	_, err := s.fetcher.Fetch(context.Background(), u)
+
+	var statusErr *HTTPStatusError
+	s.Require().True(errors.As(err, &statusErr))
+	s.Equal(http.StatusNotFound, statusErr.StatusCode)
+	s.Equal("1", statusErr.Header.Get("X-Shop"))
+	var fetchErr *FetchError
+	s.Require().True(errors.As(err, &fetchErr))
+	s.Equal(1, fetchErr.Attempts)
+	s.Equal(int32(1), s.hits.Load())
+}
+
+func (s *FetcherSuite) TestFetch_ServerErrorIsRetried() {
+	u := s.serve(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, err := s.fetcher.Fetch(context.Background(), u)
+
+	var fetchErr *FetchError
+	s.Require().True(errors.As(err, &fetchErr))
+	s.Equal(3, fetchErr.Attempts)
+	s.Equal(int32(3), s.hits.Load())
+}
+
+func (s *FetcherSuite) TestFetch_Challenge() {
+	u := s.serve(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<html><title>Just a moment...</title></html>`))
+	})
+
+	_, err := s.fetcher.Fetch(context.Background(), u)
+
+	var blockedErr *BlockedError
+	s.Require().True(errors.As(err, &blockedErr))
+	s.Equal(http.StatusForbidden, blockedErr.StatusCode)
+	s.Equal("cloudflare challenge", blockedErr.Reason)
+	s.Equal(int32(1), s.hits.Load())
+}
+
+func (s *FetcherSuite) TestFetch_CaptchaRedirect() {
+	u := s.serve(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/showcaptcha" {
+			_, _ = w.Write([]byte("<html>captcha</html>"))
+			return
+		}
+		http.Redirect(w, r, "/showcaptcha?retpath=x", http.StatusFound)
+	})
+
+	_, err := s.fetcher.Fetch(context.Background(), u+"/product/1")
+
+	var blockedErr *BlockedError
+	s.Require().True(errors.As(err, &blockedErr))
+	s.Equal("redirected to captcha", blockedErr.Reason)
+}
+
+func (s *FetcherSuite) TestFetch_BodyTooLarge() {
+	u := s.serve(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", "4096")
+		_, _ = w.Write([]byte(strings.Repeat("x", 4096)))
+	})
+	s.fetcher.cfg.TruncatePolicy = TruncateFail
+
+	_, err := s.fetcher.Fetch(context.Background(), u)
+
+	var tooLargeErr *BodyTooLargeError
+	s.Require().True(errors.As(err, &tooLargeErr))
+	s.Equal(int64(4096), tooLargeErr.Size)
+	s.Equal(int32(1), s.hits.Load())
+}
+
+func (s *FetcherSuite) TestFetch_Success() {
+	u := s.serve(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+		case "/moved":
+			http.Redirect(w, r, "/item", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("X-Shop", "1")
+			_, _ = w.Write([]byte("<html>ok</html>"))
+		}
+	})
+
+	res, err := s.fetcher.Fetch(context.Background(), u+"/old")
+
+	s.Require().NoError(err)
+	s.Equal("<html>ok</html>", string(res.Body))
+	s.Equal(u+"/old", res.URL)
+	s.Equal(u+"/item", res.FinalURL)
+	s.Equal([]string{u + "/old", u + "/moved"}, res.Redirects)
+	s.Equal(http.StatusOK, res.StatusCode)
+	s.Equal("1", res.Header.Get("X-Shop"))
+	s.Equal("text/html; charset=utf-8", res.ContentType)
+	s.Equal("utf-8", res.Charset)
+	s.Equal(1, res.Attempts)
+	s.False(res.Truncated)
+	s.Positive(res.Timings.Connect)
+	s.Positive(res.Timings.TTFB)
+	s.GreaterOrEqual(res.Timings.Total, res.Timings.TTFB)
+}
+
+func (s *FetcherSuite) TestFetch_Truncated() {
+	u := s.serve(func(w http.ResponseWriter, _ *http.Request) {
+		// chunked, so the size is only known after reading
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(strings.Repeat("x", 4096)))
+	})
+
+	// continue is the default
+	res, err := s.fetcher.Fetch(context.Background(), u)
+	s.Require().NoError(err)
+	s.True(res.Truncated)
+	s.Len(res.Body, 1024)
+
+	// the cut falls inside a two-byte letter and is moved back before it
+	cyrillic := s.serve(func(w http.ResponseWriter, _ *http.Request) {
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("x" + strings.Repeat("ц", 2048)))
+	})
+	res, err = s.fetcher.Fetch(context.Background(), cyrillic)
+	s.Require().NoError(err)
+	s.True(res.Truncated)
+	s.Equal("utf-8", res.Charset)
+	s.Equal("x"+strings.Repeat("ц", 511), string(res.Body))
+
+	s.fetcher.cfg.TruncatePolicy = TruncateFail
+	_, err = s.fetcher.Fetch(context.Background(), u)
+	var tooLargeErr *BodyTooLargeError
+	s.Require().True(errors.As(err, &tooLargeErr))
+	s.Equal(int64(0), tooLargeErr.Size)
+	s.Equal(int64(1024), tooLargeErr.Limit)
+}
+
+func (s *FetcherSuite) TestBodyLimit() {
+	f := NewFetcher(FetcherConfig{
+		MaxBodyBytes:       1024,
+		MaxBodyBytesByHost: map[string]int64{"www.Shop.example": 4096, "big.shop.example": 8192},
+	})
+
+	s.Equal(int64(1024), f.bodyLimit("other.example"))
+	s.Equal(int64(4096), f.bodyLimit("shop.example"))
+	s.Equal(int64(4096), f.bodyLimit("m.shop.example"))
+	s.Equal(int64(8192), f.bodyLimit("cdn.big.shop.example"))
+	s.Equal(int64(1024), f.bodyLimit("myshop.example"))
+}
+
+func (s *FetcherSuite) TestIsRetryable() {
+	s.False(IsRetryable(&HTTPStatusError{StatusCode: http.StatusGone}))
+	s.True(IsRetryable(&HTTPStatusError{StatusCode: http.StatusTooManyRequests}))
+	s.False(IsRetryable(&NetworkError{Kind: NetworkTLS, Permanent: true}))
+	s.True(IsRetryable(&NetworkError{Kind: NetworkTimeout}))
+	s.False(IsRetryable(context.Canceled))
+}
+
+func (s *FetcherSuite) TestLimiter_AIMD() {
+	l := newDomainLimiter(100*time.Millisecond, 500*time.Millisecond, 50*time.Millisecond)
+	throttled := &HTTPStatusError{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"2"}}}
+
+	l.Feedback("shop.example", throttled, retryAfter(throttled))
+	l.Feedback("shop.example", throttled, 0)
+	st := l.Domains()[0]
+	s.Equal(400*time.Millisecond, st.Interval)
+	s.Equal(2, st.Throttled)
+	s.WithinDuration(time.Now().Add(2*time.Second), st.PausedUntil, 100*time.Millisecond)
+
+	l.Feedback("shop.example", throttled, 0)
+	s.Equal(500*time.Millisecond, l.Domains()[0].Interval)
+
+	l.Feedback("shop.example", nil, 0)
+	s.Equal(450*time.Millisecond, l.Domains()[0].Interval)
+
+	// other errors neither slow down nor recover
+	l.Feedback("shop.example", &HTTPStatusError{StatusCode: http.StatusNotFound}, 0)
+	s.Equal(450*time.Millisecond, l.Domains()[0].Interval)
+}
+
+func (s *FetcherSuite) TestRetryAfter() {
+	s.Equal(30*time.Second, retryAfter(&HTTPStatusError{Header: http.Header{"Retry-After": {"30"}}}))
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	s.InDelta(time.Minute.Seconds(), retryAfter(&HTTPStatusError{Header: http.Header{"Retry-After": {date}}}).Seconds(), 1.5)
+	s.Zero(retryAfter(&HTTPStatusError{}))
+	s.Zero(retryAfter(nil))
+}
+
+func (s *FetcherSuite) TestBreaker() {
+	b := newCircuitBreaker(2, 50*time.Millisecond, 1)
+	down := &HTTPStatusError{StatusCode: http.StatusBadGateway}
+
+	for range 2 {
+		s.Require().NoError(b.Allow("shop.example"))
+		b.Record("shop.example", down)
+	}
+	var openErr *CircuitOpenError
+	s.Require().ErrorAs(b.Allow("shop.example"), &openErr)
+	s.Equal(BreakerOpen, b.Status()[0].State)
+
+	time.Sleep(60 * time.Millisecond)
+	s.Require().NoError(b.Allow("shop.example"))
+	// only one probe at a time while half-open
+	s.Require().ErrorAs(b.Allow("shop.example"), &openErr)
+	b.Record("shop.example", down)
+	s.Equal(BreakerOpen, b.Status()[0].State)
+
+	time.Sleep(60 * time.Millisecond)
+	s.Require().NoError(b.Allow("shop.example"))
+	b.Record("shop.example", &HTTPStatusError{StatusCode: http.StatusNotFound})
+	s.Empty(b.Status())
+	s.NoError(b.Allow("shop.example"))
+}
+
+func (s *FetcherSuite) TestFetch_BreakerFailsFast() {
+	s.fetcher = NewFetcher(FetcherConfig{
+		Retries:                 1,
+		MinBackoff:              time.Millisecond,
+		MaxBackoff:              time.Millisecond,
+		PerDomainMinInterval:    time.Millisecond,
+		BreakerFailureThreshold: 2,
+		BreakerCoolDown:         time.Minute,
+		AllowPrivateNetworks:    true,
+	})
+	u := s.serve(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	_, err := s.fetcher.Fetch(context.Background(), u)
+	var statusErr *HTTPStatusError
+	s.Require().ErrorAs(err, &statusErr)
+	s.Equal(int32(2), s.hits.Load())
+
+	_, err = s.fetcher.Fetch(context.Background(), u)
+	var openErr *CircuitOpenError
+	s.Require().ErrorAs(err, &openErr)
+	s.Equal(int32(2), s.hits.Load())
+}
+
+func (s *FetcherSuite) TestParseRobots() {
+	robots := []byte(`
+User-agent: *
+Disallow: /
+
+User-agent: price-tracker-parsing
+User-agent: other-bot
+Disallow: /cart
+Disallow: /*?sort=
+Allow: /cart/public$
+Crawl-delay: 2.5 # seconds
+`)
+
+	g := parseRobots(robots, "price-tracker-parsing")
+	s.True(g.allowed("/product/1"))
+	s.False(g.allowed("/cart"))
+	s.False(g.allowed("/cart/items"))
+	s.True(g.allowed("/cart/public"))
+	s.False(g.allowed("/cart/public/x"))
+	s.False(g.allowed("/catalog?sort=price"))
+	s.Equal(2500*time.Millisecond, g.crawlDelay)
+
+	// agents without their own group fall back to "*"
+	s.False(parseRobots(robots, "someone-else").allowed("/product/1"))
+	s.True(parseRobots(nil, "price-tracker-parsing").allowed("/anything"))
+}
+
+func (s *FetcherSuite) TestFetch_RobotsDisallowed() {
+	s.fetcher = NewFetcher(FetcherConfig{
+		UserAgent:            "price-tracker-parsing/1.0",
+		PerDomainMinInterval: time.Millisecond,
+		RespectRobots:        true,
+		AllowPrivateNetworks: true,
+	})
+	u := s.serve(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			_, _ = w.Write([]byte("User-agent: *\nDisallow: /private\nCrawl-delay: 1\n"))
+			return
+		}
+		_, _ = w.Write([]byte("<html>ok</html>"))
+	})
+
+	_, err := s.fetcher.Fetch(context.Background(), u+"/private/item")
+	var disallowedErr *DisallowedError
+	s.Require().ErrorAs(err, &disallowedErr)
+
+	_, err = s.fetcher.Fetch(context.Background(), u+"/item")
+	s.Require().NoError(err)
+	// robots.txt is fetched once, the disallowed page never
+	s.Equal(int32(2), s.hits.Load())
+	s.Equal(time.Second, s.fetcher.Domains()[0].CrawlDelay)
+}
+
+func (s *FetcherSuite) TestFetch_RobotsUnreachable() {
+	s.fetcher = NewFetcher(FetcherConfig{
+		PerDomainMinInterval: time.Millisecond,
+		RespectRobots:        true,
+		AllowPrivateNetworks: true,
+	})
+	u := s.serve(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("<html>ok</html>"))
+	})
+
+	for range 2 {
+		_, err := s.fetcher.Fetch(context.Background(), u+"/item")
+		var robotsErr *RobotsUnavailableError
+		s.Require().ErrorAs(err, &robotsErr)
+		var statusErr *HTTPStatusError
+		s.Require().ErrorAs(err, &statusErr)
+		s.Equal(http.StatusServiceUnavailable, statusErr.StatusCode)
+		var disallowedErr *DisallowedError
+		s.NotErrorAs(err, &disallowedErr)
+	}
+	// the failure is cached for a while, the page is never fetched
+	s.Equal(int32(1), s.hits.Load())
+	// the shop being down counts towards its circuit breaker
+	s.Require().Len(s.fetcher.Breakers(), 1)
+	s.Equal(1, s.fetcher.Breakers()[0].Failures)
+}
+
+func (s *FetcherSuite) TestFetch_RobotsCancelledIsNotCached() {
+	s.fetcher = NewFetcher(FetcherConfig{
+		PerDomainMinInterval: time.Millisecond,
+		RespectRobots:        true,
+		AllowPrivateNetworks: true,
+	})
+	var robots atomic.Int32
+	u := s.serve(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			if robots.Add(1) == 1 {
+				// hold the first download until its caller gives up
+				<-r.Context().Done()
+				return
+			}
+			_, _ = w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+			return
+		}
+		_, _ = w.Write([]byte("<html>ok</html>"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := s.fetcher.Fetch(ctx, u+"/item")
+		first <- err
+	}()
+	s.Require().Eventually(func() bool { return robots.Load() == 1 }, time.Second, time.Millisecond)
+
+	// a caller waiting for the same download gets its own after the cancel
+	second := make(chan error, 1)
+	go func() {
+		_, err := s.fetcher.Fetch(context.Background(), u+"/private/item")
+		second <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	s.ErrorIs(<-first, context.Canceled)
+	var disallowedErr *DisallowedError
+	s.Require().ErrorAs(<-second, &disallowedErr)
+
+	_, err := s.fetcher.Fetch(context.Background(), u+"/item")
+	s.Require().NoError(err)
+	s.Equal(int32(2), robots.Load())
+}
+
+func (s *FetcherSuite) TestFetch_ForbiddenAddress() {
+	s.fetcher = NewFetcher(FetcherConfig{PerDomainMinInterval: time.Millisecond})
+	u := s.serve(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("<html>internal</html>"))
+	})
+
+	for _, target := range []string{u, "http://localhost/admin", "http://169.254.169.254/latest/meta-data", "http://[::1]:8080/"} {
+		_, err := s.fetcher.Fetch(context.Background(), target)
+		var forbidden *ForbiddenAddressError
+		s.Require().ErrorAs(err, &forbidden, target)
+		s.False(IsRetryable(err))
+	}
+	_, err := s.fetcher.Fetch(context.Background(), "file:///etc/passwd")
+	s.ErrorContains(err, "unsupported url scheme")
+	s.Equal(int32(0), s.hits.Load())
+
+	// a name is checked on the address it resolves to
+	_, port, _ := strings.Cut(strings.TrimPrefix(u, "http://"), ":")
+	_, err = guardedDialer()(context.Background(), "tcp", "localhost:"+port)
+	var forbidden *ForbiddenAddressError
+	s.ErrorAs(err, &forbidden)
+}
+
+func (s *FetcherSuite) TestIsPublicAddr() {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2a00:1450::1":     true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::":               false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		s.Equal(want, isPublicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func (s *FetcherSuite) TestFetch_Charset() {
+	cp1251, err := charmap.Windows1251.NewEncoder().String("Цена: 1 990 руб.")
+	s.Require().NoError(err)
+	koi8, err := charmap.KOI8R.NewEncoder().String("Цена: 1 990 руб.")
+	s.Require().NoError(err)
+
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"header", "text/html; charset=windows-1251", cp1251, "windows-1251"},
+		{"meta charset", "text/html", `<html><head><meta charset="koi8-r"></head><body>` + koi8, "koi8-r"},
+		{"http-equiv", "text/html", `<meta http-equiv="Content-Type" content="text/html; charset=koi8-r">` + koi8, "koi8-r"},
+		{"bom", "text/html; charset=windows-1251", "\xEF\xBB\xBFЦена: 1 990 руб.", "utf-8"},
+		{"undeclared utf-8", "text/html", "Цена: 1 990 руб.", "utf-8"},
+		{"undeclared utf-8 with a stray byte", "text/html", strings.Repeat("Цена: 1 990 руб. ", 20) + "\xA0", "utf-8"},
+		{"undeclared legacy", "text/html", cp1251, "windows-1251"},
+	}
+	for _, c := range cases {
+		s.Run(c.name, func() {
+			body, cs := toUTF8([]byte(c.body), c.contentType)
+			s.Equal(c.want, cs)
+			s.Contains(string(body), "Цена: 1 990 руб.")
+			s.False(strings.HasPrefix(string(body), "\xEF\xBB\xBF"))
+		})
+	}
+
+	u := s.serve(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+		_, _ = w.Write([]byte(cp1251))
+	})
+	res, err := s.fetcher.Fetch(context.Background(), u)
+	s.Require().NoError(err)
+	s.Equal("Цена: 1 990 руб.", string(res.Body))
+	s.Equal("windows-1251", res.Charset)
+}
+
+func TestFetcherSuite(t *testing.T) {
+	suite.Run(t, new(FetcherSuite))
+}
@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// reservedPrefixes are non-public ranges that netip.Addr has no predicate for.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// isPublicAddr reports whether addr is a public unicast address a shop page
+// may live on.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, p := range reservedPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckTarget rejects URLs the fetcher must not request: schemes other than
+// http and https, and hosts that are "localhost" or a non-public IP literal.
+// Names resolving to a non-public address are rejected when connecting.
+func CheckTarget(rawURL string) error {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return &ForbiddenAddressError{Addr: host}
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublicAddr(addr) {
+		return &ForbiddenAddressError{Addr: host}
+	}
+	return nil
+}
+
+// guardedDialer dials only public addresses. The check runs on the resolved
+// address right before connecting, so it also covers redirects and names
+// that resolve differently on the next lookup. Proxies from the environment
+// are dialled without the check: they are trusted by configuration.
+func guardedDialer() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	guarded := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return &ForbiddenAddressError{Addr: address}
+			}
+			if !isPublicAddr(ap.Addr()) {
+				return &ForbiddenAddressError{Addr: address}
+			}
+			return nil
+		},
+	}
+	plain := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	proxies := proxyAddrs(httpproxy.FromEnvironment())
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if proxies[addr] {
+			return plain.DialContext(ctx, network, addr)
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
+
+// proxyAddrs returns the host:port of the configured HTTP and HTTPS proxies.
+func proxyAddrs(cfg *httpproxy.Config) map[string]bool {
+	addrs := make(map[string]bool, 2)
+	for _, raw := range []string{cfg.HTTPProxy, cfg.HTTPSProxy} {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			// "proxy:3128" without a scheme
+			u, err = url.Parse("http://" + raw)
+			if err != nil {
+				continue
+			}
+		}
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		addrs[net.JoinHostPort(u.Hostname(), port)] = true
+	}
+	return addrs
+}
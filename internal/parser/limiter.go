@@ -0,0 +1,201 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LehaAlexey/Parsing/internal/metrics"
+)
+
+// DomainState is a snapshot of the rate limit of one host.
+type DomainState struct {
+	Host         string        `json:"host"`
+	Interval     time.Duration `json:"-"`
+	IntervalMS   int64         `json:"interval_ms"`
+	CrawlDelay   time.Duration `json:"-"`
+	CrawlDelayMS int64         `json:"crawl_delay_ms,omitempty"`
+	PausedUntil  time.Time     `json:"paused_until,omitzero"`
+	Throttled    int           `json:"throttled"`
+	LastRequest  time.Time     `json:"last_request"`
+}
+
+type hostLimit struct {
+	interval    time.Duration
+	crawlDelay  time.Duration
+	next        time.Time
+	pausedUntil time.Time
+	throttled   int
+	lastRequest time.Time
+}
+
+// domainLimiter spaces requests to one host by an interval that adapts to the
+// shop: it doubles (up to maxInterval) whenever the shop answers 429 or 503
+// and shrinks by recoveryStep after every successful response, back down to
+// minInterval or the robots.txt Crawl-delay, whichever is larger. A
+// Retry-After hint pauses the host entirely.
+type domainLimiter struct {
+	minInterval  time.Duration
+	maxInterval  time.Duration
+	recoveryStep time.Duration
+	mu           sync.Mutex
+	hosts        map[string]*hostLimit
+}
+
+func newDomainLimiter(minInterval, maxInterval, recoveryStep time.Duration) *domainLimiter {
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+	return &domainLimiter{
+		minInterval:  minInterval,
+		maxInterval:  maxInterval,
+		recoveryStep: recoveryStep,
+		hosts:        make(map[string]*hostLimit),
+	}
+}
+
+func (l *domainLimiter) host(host string) *hostLimit {
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostLimit{interval: l.minInterval}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+func (l *domainLimiter) Wait(ctx context.Context, host string) error {
+	l.mu.Lock()
+	h := l.host(host)
+	now := time.Now()
+	at := now
+	if h.next.After(at) {
+		at = h.next
+	}
+	if h.pausedUntil.After(at) {
+		at = h.pausedUntil
+	}
+	h.next = at.Add(h.interval)
+	h.lastRequest = at
+	l.mu.Unlock()
+
+	wait := at.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Feedback adapts the host interval to the outcome of a request. retryAfter
+// is the (already capped) server hint, zero if there was none.
+func (l *domainLimiter) Feedback(host string, err error, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	h := l.host(host)
+	floor := max(l.minInterval, h.crawlDelay)
+	if isThrottled(err) {
+		h.interval = min(max(h.interval*2, floor, time.Millisecond), max(l.maxInterval, floor))
+		h.throttled++
+		if retryAfter > 0 {
+			h.pausedUntil = time.Now().Add(retryAfter)
+		}
+		metrics.LimiterThrottled.WithLabelValues(host).Inc()
+	} else if err == nil && h.interval > floor {
+		h.interval = max(h.interval-l.recoveryStep, floor)
+	}
+	metrics.LimiterInterval.WithLabelValues(host).Set(h.interval.Seconds())
+}
+
+// SetCrawlDelay makes d the lowest interval of the host.
+func (l *domainLimiter) SetCrawlDelay(host string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	h := l.host(host)
+	h.crawlDelay = d
+	h.interval = max(h.interval, d)
+	metrics.LimiterInterval.WithLabelValues(host).Set(h.interval.Seconds())
+}
+
+// Domains returns the state of every host seen so far, slowest first.
+func (l *domainLimiter) Domains() []DomainState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	out := make([]DomainState, 0, len(l.hosts))
+	for host, h := range l.hosts {
+		st := DomainState{
+			Host:         host,
+			Interval:     h.interval,
+			IntervalMS:   h.interval.Milliseconds(),
+			CrawlDelay:   h.crawlDelay,
+			CrawlDelayMS: h.crawlDelay.Milliseconds(),
+			Throttled:    h.throttled,
+			LastRequest:  h.lastRequest,
+		}
+		if h.pausedUntil.After(now) {
+			st.PausedUntil = h.pausedUntil
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Interval != out[j].Interval {
+			return out[i].Interval > out[j].Interval
+		}
+		return out[i].Host < out[j].Host
+	})
+	return out
+}
+
+// isThrottled reports whether the shop asked us to slow down.
+func isThrottled(err error) bool {
+	code := 0
+	var statusErr *HTTPStatusError
+	var blockedErr *BlockedError
+	switch {
+	case errors.As(err, &statusErr):
+		code = statusErr.StatusCode
+	case errors.As(err, &blockedErr):
+		code = blockedErr.StatusCode
+	}
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// retryAfter returns the Retry-After hint of a failed response, either in
+// seconds or as an HTTP date.
+func retryAfter(err error) time.Duration {
+	var header http.Header
+	var statusErr *HTTPStatusError
+	var blockedErr *BlockedError
+	switch {
+	case errors.As(err, &statusErr):
+		header = statusErr.Header
+	case errors.As(err, &blockedErr):
+		header = blockedErr.Header
+	}
+	v := strings.TrimSpace(header.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,264 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/LehaAlexey/Parsing/internal/currency"
+	"golang.org/x/net/html"
+)
+
+var (
+	// errAmbiguousNumber is returned for numbers like "1.299" whose separator
+	// may be either decimal or grouping when no hint settles it.
+	errAmbiguousNumber = errors.New("ambiguous number")
+	errMalformedNumber = errors.New("malformed number")
+)
+
+// numberPattern matches a price with any of the separators parseNumber
+// understands.
+const numberPattern = `[0-9][0-9\s\x{00A0}\x{202F}\x{2009}\x{2007}'\x{2019}.,]{0,20}`
+
+// numberHints tell which decimal separator a page uses. They are consulted
+// in order: the lang of the page, the TLD of its host, then the currency.
+type numberHints struct {
+	lang     string
+	tld      string
+	currency string
+	// machine is set for machine-readable values (JSON numbers, schema.org
+	// and Open Graph prices), where '.' is always the decimal separator and
+	// the hints are not consulted for it.
+	machine bool
+}
+
+// commaLangs, commaTLDs and commaCurrs point to ',' as the decimal separator,
+// the dot ones to '.'.
+var (
+	commaLangs = wordSet("ru uk be kk uz ky hy ka az de fr es it pt pl cs sk sl hr sr bg ro hu nl da sv nb nn no fi et lv lt tr el id vi")
+	dotLangs   = wordSet("en ja zh ko th he hi ms fil")
+	// Swiss German, French and Italian write 1'234.50.
+	dotRegions  = wordSet("ch li")
+	commaTLDs   = wordSet("ru su by kz ua uz kg am ge az de at fr be es it pt pl cz sk si hr rs bg ro hu nl dk se no fi ee lv lt tr gr id vn")
+	dotTLDs     = wordSet("us uk au nz ca ie jp cn kr tw hk sg in il th my ph ch li")
+	commaCurrs  = wordSet("RUB BYN KZT UAH UZS KGS AMD GEL AZN EUR PLN CZK HUF RON BGN RSD DKK SEK NOK TRY IDR VND")
+	dotCurrs    = wordSet("USD GBP AUD NZD CAD JPY CNY KRW TWD HKD SGD INR ILS THB MYR PHP CHF")
+	numberSpace = map[rune]bool{' ': true, '\u00a0': true, '\u202f': true, '\u2009': true, '\u2007': true}
+	numberQuote = map[rune]bool{'\'': true, '\u2019': true, '\u02bc': true}
+)
+
+func wordSet(words string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		m[w] = true
+	}
+	return m
+}
+
+// decimalSep returns the decimal separator the hints point to, or 0 when
+// they say nothing.
+func (h numberHints) decimalSep() rune {
+	if h.lang != "" {
+		lang, region, _ := strings.Cut(strings.ToLower(strings.ReplaceAll(h.lang, "_", "-")), "-")
+		switch {
+		case dotRegions[region]:
+			return '.'
+		case commaLangs[lang]:
+			return ','
+		case dotLangs[lang]:
+			return '.'
+		}
+	}
+	switch {
+	case commaTLDs[h.tld]:
+		return ','
+	case dotTLDs[h.tld]:
+		return '.'
+	}
+	switch c := strings.ToUpper(h.currency); {
+	case commaCurrs[c]:
+		return ','
+	case dotCurrs[c]:
+		return '.'
+	}
+	return 0
+}
+
+// pageHints reads the lang attribute of <html> and the TLD of the page URL.
+func pageHints(pageURL string, htmlBytes []byte) numberHints {
+	var h numberHints
+	if u, err := url.Parse(pageURL); err == nil {
+		host := strings.ToLower(u.Hostname())
+		h.tld = host[strings.LastIndexByte(host, '.')+1:]
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(htmlBytes))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return h
+		case html.StartTagToken:
+			t := z.Token()
+			if t.Data != "html" {
+				// <html> is the first element or absent
+				return h
+			}
+			for _, a := range t.Attr {
+				if strings.EqualFold(a.Key, "lang") {
+					h.lang = strings.TrimSpace(a.Val)
+				}
+			}
+			return h
+		}
+	}
+}
+
+// parseNumber reads the first number in s, e.g. "1 234,56", "1,299.00",
+// "1.299.000" or "1'234.50". Spaces (including NBSP, narrow NBSP and thin
+// space) and apostrophes only group digits. With both '.' and ',' present the
+// last one is the decimal separator; a single separator repeated is grouping.
+// A single separator followed by exactly three digits, e.g. "1.299", is
+// resolved by the currency (three fraction digits are impossible for one
+// with two minor digits) and then by the hints. A machine-readable value
+// only has '.' as the decimal separator: "1.299" is 1.299 there, and a
+// grouping '.' is malformed.
+func parseNumber(s string, h numberHints) (Decimal, error) {
+	token := numberToken(s)
+	if token == "" {
+		return Decimal{}, errMalformedNumber
+	}
+
+	// spaces and apostrophes are grouping only
+	var b strings.Builder
+	for _, r := range token {
+		if numberSpace[r] || numberQuote[r] {
+			b.WriteByte(' ')
+			continue
+		}
+		b.WriteRune(r)
+	}
+	token = b.String()
+	if err := checkGroups(token, " "); err != nil {
+		return Decimal{}, err
+	}
+	token = strings.ReplaceAll(token, " ", "")
+
+	dot, comma := strings.Count(token, "."), strings.Count(token, ",")
+	var intPart, frac string
+	switch {
+	case dot == 0 && comma == 0:
+		intPart = token
+	case dot > 0 && comma > 0:
+		dec := "."
+		if strings.LastIndex(token, ",") > strings.LastIndex(token, ".") {
+			dec = ","
+		}
+		group := map[string]string{".": ",", ",": "."}[dec]
+		if h.machine && dec != "." || strings.Count(token, dec) > 1 {
+			return Decimal{}, errMalformedNumber
+		}
+		intPart, frac, _ = strings.Cut(token, dec)
+		if strings.Contains(frac, group) {
+			return Decimal{}, errMalformedNumber
+		}
+		if err := checkGroups(intPart, group); err != nil {
+			return Decimal{}, err
+		}
+		intPart = strings.ReplaceAll(intPart, group, "")
+	default:
+		sep := "."
+		if comma > 0 {
+			sep = ","
+		}
+		if strings.Count(token, sep) > 1 {
+			if h.machine && sep == "." {
+				return Decimal{}, errMalformedNumber
+			}
+			if err := checkGroups(token, sep); err != nil {
+				return Decimal{}, err
+			}
+			intPart = strings.ReplaceAll(token, sep, "")
+			break
+		}
+		intPart, frac, _ = strings.Cut(token, sep)
+		// "0.299" and "1299.000" can not be grouping
+		if len(frac) == 3 && len(intPart) <= 3 && strings.TrimLeft(intPart, "0") != "" {
+			grouping, err := resolveThreeDigits(rune(sep[0]), h)
+			if err != nil {
+				return Decimal{}, fmt.Errorf("%w: %q", err, token)
+			}
+			if grouping {
+				if err := checkGroups(token, sep); err != nil {
+					return Decimal{}, err
+				}
+				intPart, frac = intPart+frac, ""
+			}
+		}
+	}
+
+	d, ok := parseDecimal(intPart + "." + frac)
+	if !ok {
+		return Decimal{}, errMalformedNumber
+	}
+	return d, nil
+}
+
+// resolveThreeDigits decides whether sep in "1<sep>299" groups thousands.
+func resolveThreeDigits(sep rune, h numberHints) (bool, error) {
+	if h.machine && sep == '.' {
+		return false, nil
+	}
+	if h.currency != "" {
+		if exp, ok := currency.Exponent(h.currency); ok && exp < 3 {
+			return true, nil
+		}
+	}
+	if dec := h.decimalSep(); dec != 0 {
+		return dec != sep, nil
+	}
+	return false, errAmbiguousNumber
+}
+
+// checkGroups verifies that sep splits s into a leading group of 1-3 digits
+// followed by groups of exactly 3. The part after a decimal separator, if
+// any, is only checked for being the tail of the last group.
+func checkGroups(s, sep string) error {
+	parts := strings.Split(s, sep)
+	if len(parts) == 1 {
+		return nil
+	}
+	for i, p := range parts {
+		digits := p
+		if i == len(parts)-1 {
+			// "1 234,56": the last group carries the fraction
+			if j := strings.IndexAny(p, ".,"); j >= 0 && sep == " " {
+				digits = p[:j]
+			}
+		}
+		if i > 0 && len(digits) != 3 || len(digits) == 0 || len(digits) > 3 {
+			return errMalformedNumber
+		}
+	}
+	return nil
+}
+
+// numberToken returns the first run of digits and separators in s, without
+// trailing separators.
+func numberToken(s string) string {
+	start := strings.IndexFunc(s, func(r rune) bool { return r >= '0' && r <= '9' })
+	if start < 0 {
+		return ""
+	}
+	end := start
+	for end < len(s) {
+		r, size := utf8.DecodeRuneInString(s[end:])
+		if !(r >= '0' && r <= '9' || r == '.' || r == ',' || numberSpace[r] || numberQuote[r]) {
+			break
+		}
+		end += size
+	}
+	return strings.TrimRightFunc(s[start:end], func(r rune) bool { return r < '0' || r > '9' })
+}
@@ -0,0 +1,201 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// oldPriceKeys name the list (pre-discount) price in embedded JSON.
+var oldPriceKeys = []string{
+	"oldPrice", "old_price", "priceOld", "price_old",
+	"listPrice", "list_price", "originalPrice", "original_price",
+	"regularPrice", "regular_price", "basePrice", "base_price",
+	"fullPrice", "full_price", "crossedPrice", "crossed_price",
+	"strikethroughPrice", "strikethrough_price", "wasPrice", "was_price",
+}
+
+// oldPriceClasses mark elements holding a crossed-out price.
+var oldPriceClasses = []string{"old-price", "price-old", "old_price", "price_old", "oldprice", "price--old", "was-price", "crossed", "strikethrough"}
+
+// findOldPrice looks for the price before discount in scope: a list or
+// strikethrough price in JSON-LD priceSpecification, highPrice or a common
+// old price key of the offer or its product, then <del>, <s>, <strike> or an
+// element with an "old price" class around the price. Only a value above
+// price, and at most maxOldPriceRatio times it, is accepted.
+func findOldPrice(scope priceScope, price Decimal, hints numberHints) (Decimal, bool) {
+	var candidates []oldPriceCandidate
+	for _, obj := range scope.objects {
+		candidates = append(candidates, oldPricesInJSON(obj)...)
+	}
+	if scope.container != nil {
+		for _, raw := range struckTexts(scope.container) {
+			candidates = append(candidates, oldPriceCandidate{raw: raw})
+		}
+	}
+
+	for _, c := range candidates {
+		h := hints
+		h.machine = c.machine
+		if old, ok := parseOldPrice(c.raw, price, h); ok {
+			return old, true
+		}
+	}
+	return Decimal{}, false
+}
+
+// oldPriceCandidate is a raw old price. machine is set for JSON numbers and
+// schema.org values, see numberHints.
+type oldPriceCandidate struct {
+	raw     string
+	machine bool
+}
+
+// maxOldPriceRatio rejects "old prices" that are more likely another product
+// (a discount above 95%).
+const maxOldPriceRatio = 20
+
+func parseOldPrice(raw string, price Decimal, hints numberHints) (Decimal, bool) {
+	if raw == "" {
+		return Decimal{}, false
+	}
+	old, err := parseNumber(raw, hints)
+	if err != nil || !greater(old, price) {
+		return Decimal{}, false
+	}
+	if greater(old, Decimal{Value: price.Value * maxOldPriceRatio, Scale: price.Scale}) {
+		return Decimal{}, false
+	}
+	return old, true
+}
+
+// greater reports whether a > b.
+func greater(a, b Decimal) bool {
+	scale := max(a.Scale, b.Scale)
+	av, aok := a.Rescale(scale)
+	bv, bok := b.Rescale(scale)
+	return aok && bok && av > bv
+}
+
+// scriptJSONs returns the JSON documents found in <script> tags, JSON-LD
+// first.
+func scriptJSONs(b []byte) []any {
+	var ld, other []any
+	z := html.NewTokenizer(bytes.NewReader(b))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return append(ld, other...)
+		case html.StartTagToken:
+			t := z.Token()
+			if t.Data != "script" {
+				continue
+			}
+			isLD := false
+			for _, a := range t.Attr {
+				if strings.EqualFold(a.Key, "type") && strings.Contains(strings.ToLower(a.Val), "ld+json") {
+					isLD = true
+				}
+			}
+			if z.Next() != html.TextToken {
+				continue
+			}
+			raw := strings.TrimSpace(string(z.Text()))
+			if v, ok := decodeScriptJSON(raw); ok {
+				if isLD {
+					ld = append(ld, v)
+				} else {
+					other = append(other, v)
+				}
+			}
+		}
+	}
+}
+
+// oldPricesInJSON collects the old price candidates of an offer or product
+// object. Nested objects are not searched: they describe other offers.
+func oldPricesInJSON(x map[string]any) []oldPriceCandidate {
+	var out []oldPriceCandidate
+	if p, ok := firstKey(x, oldPriceKeys...); ok {
+		if s := toString(p); s != "" {
+			_, number := p.(float64)
+			out = append(out, oldPriceCandidate{raw: s, machine: number})
+		}
+	}
+	if spec, ok := x["priceSpecification"]; ok {
+		for _, s := range listPrices(spec) {
+			out = append(out, oldPriceCandidate{raw: s, machine: true})
+		}
+	}
+	if p, ok := x["highPrice"]; ok {
+		if s := toString(p); s != "" {
+			out = append(out, oldPriceCandidate{raw: s, machine: true})
+		}
+	}
+	return out
+}
+
+// listPrices returns the prices of schema.org price specifications typed as
+// ListPrice or StrikethroughPrice.
+func listPrices(spec any) []string {
+	var out []string
+	switch x := spec.(type) {
+	case map[string]any:
+		t := strings.ToLower(toString(x["priceType"]))
+		if strings.HasSuffix(t, "listprice") || strings.HasSuffix(t, "strikethroughprice") {
+			if s := toString(x["price"]); s != "" {
+				out = append(out, s)
+			}
+		}
+	case []any:
+		for _, v := range x {
+			out = append(out, listPrices(v)...)
+		}
+	}
+	return out
+}
+
+// struckTexts returns the text of crossed-out elements inside n.
+func struckTexts(n *html.Node) []string {
+	var out []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom == atom.Script || c.DataAtom == atom.Style {
+			continue
+		}
+		if isStruck(c.Data, c.Attr) {
+			if s := nodeText(c); s != "" {
+				out = append(out, s)
+			}
+			continue
+		}
+		out = append(out, struckTexts(c)...)
+	}
+	return out
+}
+
+// isStruck reports whether an element holds a crossed-out price.
+func isStruck(tag string, attrs []html.Attribute) bool {
+	switch tag {
+	case "del", "s", "strike":
+		return true
+	}
+	for _, a := range attrs {
+		if !strings.EqualFold(a.Key, "class") {
+			continue
+		}
+		class := strings.ToLower(a.Val)
+		for _, c := range oldPriceClasses {
+			if strings.Contains(class, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
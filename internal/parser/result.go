@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// FetchResult is a fetched page, transcoded to UTF-8, with the details of the
+// response that produced it.
+type FetchResult struct {
+	Body []byte
+	// URL is the requested URL after normalisation; FinalURL is where the
+	// redirects ended.
+	URL         string
+	FinalURL    string
+	StatusCode  int
+	Header      http.Header
+	ContentType string
+	// Charset is the detected charset of the original body.
+	Charset string
+	// Redirects lists the URLs that redirected, in order, FinalURL excluded.
+	Redirects []string
+	// Attempts counts the requests made, retries included.
+	Attempts int
+	// Truncated is set when the body was cut at the limit of the host under
+	// TruncateContinue.
+	Truncated bool
+	Timings   FetchTimings
+}
+
+// FetchTimings are the phases of the successful attempt. DNS, Connect and TLS
+// are summed over redirects and are zero for a reused connection.
+type FetchTimings struct {
+	// Wait is the time spent in the per-domain rate limiter.
+	Wait    time.Duration
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TTFB is from the start of the request to the first byte of the final
+	// response, redirects included.
+	TTFB  time.Duration
+	Total time.Duration
+}
+
+// fetchTrace collects FetchTimings through httptrace. Callbacks of parallel
+// dials may run concurrently.
+type fetchTrace struct {
+	mu      sync.Mutex
+	start   time.Time
+	dns     time.Time
+	connect time.Time
+	tls     time.Time
+	timings FetchTimings
+}
+
+func newFetchTrace() *fetchTrace {
+	return &fetchTrace{start: time.Now()}
+}
+
+func (t *fetchTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dns = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.timings.DNS += time.Since(t.dns)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			if t.connect.IsZero() {
+				t.connect = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			if err == nil && !t.connect.IsZero() {
+				t.timings.Connect += time.Since(t.connect)
+				t.connect = time.Time{}
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tls = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.timings.TLS += time.Since(t.tls)
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.timings.TTFB = time.Since(t.start)
+			t.mu.Unlock()
+		},
+	}
+}
+
+func (t *fetchTrace) done() FetchTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timings.Total = time.Since(t.start)
+	return t.timings
+}
+
+// redirectChain returns the URLs that led to resp, oldest first.
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for r := resp.Request; r != nil && r.Response != nil; r = r.Response.Request {
+		if r.Response.Request == nil || r.Response.Request.URL == nil {
+			break
+		}
+		chain = append(chain, r.Response.Request.URL.String())
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
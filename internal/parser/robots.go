@@ -0,0 +1,312 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	robotsMaxBytes = 500 << 10
+	// robotsRetryTTL is how long an unreachable robots.txt disallows the host
+	// before it is requested again.
+	robotsRetryTTL = time.Minute
+)
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newRobotsRule(allow bool, pattern string) robotsRule {
+	anchored := strings.HasSuffix(pattern, "$")
+	parts := strings.Split(strings.TrimSuffix(pattern, "$"), "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return robotsRule{allow: allow, pattern: pattern, re: regexp.MustCompile(expr)}
+}
+
+// robotsGroup holds the rules that apply to our user agent.
+type robotsGroup struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// allowed applies the longest matching rule, Allow winning ties (RFC 9309).
+func (g *robotsGroup) allowed(path string) bool {
+	best, allow := -1, true
+	for _, r := range g.rules {
+		if !r.re.MatchString(path) {
+			continue
+		}
+		if len(r.pattern) > best || (len(r.pattern) == best && r.allow) {
+			best, allow = len(r.pattern), r.allow
+		}
+	}
+	return allow
+}
+
+// parseRobots returns the group for agent: the group naming the agent, or
+// the "*" group if there is none.
+func parseRobots(body []byte, agent string) *robotsGroup {
+	agent = strings.ToLower(agent)
+
+	var (
+		specific, wildcard *robotsGroup
+		current            []*robotsGroup
+		inRules            bool
+	)
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 0, 64<<10), robotsMaxBytes)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if inRules {
+				current, inRules = nil, false
+			}
+			ua := strings.ToLower(value)
+			switch {
+			case ua == "*":
+				if wildcard == nil {
+					wildcard = &robotsGroup{}
+				}
+				current = append(current, wildcard)
+			case agent != "" && ua == agent:
+				if specific == nil {
+					specific = &robotsGroup{}
+				}
+				current = append(current, specific)
+			default:
+				// a group for another crawler; its rules are skipped
+				current = append(current, nil)
+			}
+		case "allow", "disallow":
+			inRules = true
+			// an empty Disallow allows everything
+			if value == "" {
+				continue
+			}
+			for _, g := range current {
+				if g != nil {
+					g.rules = append(g.rules, newRobotsRule(key == "allow", value))
+				}
+			}
+		case "crawl-delay":
+			inRules = true
+			secs, err := strconv.ParseFloat(value, 64)
+			if err != nil || secs <= 0 {
+				continue
+			}
+			for _, g := range current {
+				if g != nil {
+					g.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	switch {
+	case specific != nil:
+		return specific
+	case wildcard != nil:
+		return wildcard
+	default:
+		return &robotsGroup{}
+	}
+}
+
+type robotsEntry struct {
+	ready chan struct{}
+	group *robotsGroup
+	// err is set while robots.txt is unreachable; every URL of the host is
+	// disallowed until the entry expires.
+	err     error
+	expires time.Time
+	// cancelled is set when the download was abandoned by its caller; the
+	// entry is not cached and waiters look the host up again.
+	cancelled bool
+}
+
+// robotsCache fetches robots.txt once per host and TTL. Concurrent lookups of
+// the same host wait for a single download.
+type robotsCache struct {
+	ttl       time.Duration
+	agent     string
+	userAgent string
+	client    *http.Client
+	limiter   *domainLimiter
+	breaker   *circuitBreaker
+
+	mu    sync.Mutex
+	hosts map[string]*robotsEntry
+}
+
+func newRobotsCache(ttl time.Duration, userAgent string, client *http.Client, limiter *domainLimiter, breaker *circuitBreaker) *robotsCache {
+	agent, _, _ := strings.Cut(userAgent, "/")
+	return &robotsCache{
+		ttl:       ttl,
+		agent:     strings.TrimSpace(agent),
+		userAgent: userAgent,
+		client:    client,
+		limiter:   limiter,
+		breaker:   breaker,
+		hosts:     make(map[string]*robotsEntry),
+	}
+}
+
+// Check returns a DisallowedError if robots.txt of the host forbids u for
+// our user agent, and a RobotsUnavailableError if it can not be fetched.
+func (c *robotsCache) Check(ctx context.Context, host string, u *url.URL) error {
+	e, err := c.entry(ctx, host, u)
+	if err != nil {
+		return err
+	}
+	if e.err != nil {
+		var openErr *CircuitOpenError
+		if errors.As(e.err, &openErr) {
+			return e.err
+		}
+		return &RobotsUnavailableError{URL: u.String(), Err: e.err}
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if !e.group.allowed(path) {
+		return &DisallowedError{URL: u.String()}
+	}
+	return nil
+}
+
+func (c *robotsCache) entry(ctx context.Context, host string, u *url.URL) (*robotsEntry, error) {
+	for {
+		c.mu.Lock()
+		e, ok := c.hosts[host]
+		if ok && !e.expires.IsZero() && time.Now().After(e.expires) {
+			ok = false
+		}
+		if !ok {
+			e = &robotsEntry{ready: make(chan struct{})}
+			c.hosts[host] = e
+			c.mu.Unlock()
+			return c.fill(ctx, host, u, e)
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-e.ready:
+		}
+		if !e.cancelled {
+			return e, nil
+		}
+	}
+}
+
+// fill downloads robots.txt into e. A download cut short by the caller's
+// context says nothing about the host, so it is dropped instead of cached.
+func (c *robotsCache) fill(ctx context.Context, host string, u *url.URL, e *robotsEntry) (*robotsEntry, error) {
+	group, ttl, err := c.load(ctx, host, u)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		c.mu.Lock()
+		if c.hosts[host] == e {
+			delete(c.hosts, host)
+		}
+		c.mu.Unlock()
+		e.cancelled = true
+		close(e.ready)
+		return nil, ctxErr
+	}
+
+	e.group, e.err, e.expires = group, err, time.Now().Add(ttl)
+	if group != nil {
+		c.limiter.SetCrawlDelay(host, group.crawlDelay)
+	}
+	close(e.ready)
+	return e, nil
+}
+
+// load downloads robots.txt. A missing file (4xx) allows everything; an
+// unreachable one (network error, 5xx) disallows everything for a short time
+// only, so a shop being down does not block it for the whole TTL. The
+// download counts towards the circuit breaker of the host like a page fetch;
+// while the circuit is open nothing is downloaded or cached.
+func (c *robotsCache) load(ctx context.Context, host string, u *url.URL) (*robotsGroup, time.Duration, error) {
+	if err := c.limiter.Wait(ctx, host); err != nil {
+		return nil, 0, err
+	}
+
+	robotsURL := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL.String(), nil)
+	if err != nil {
+		return nil, robotsRetryTTL, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	if err := c.breaker.Allow(host); err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		err = classifyNetworkError(err)
+		c.record(ctx, host, err)
+		return nil, robotsRetryTTL, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, robotsMaxBytes))
+		if err != nil {
+			err = classifyNetworkError(err)
+			c.record(ctx, host, err)
+			return nil, robotsRetryTTL, err
+		}
+		c.record(ctx, host, nil)
+		return parseRobots(body, c.agent), c.ttl, nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		c.record(ctx, host, nil)
+		return &robotsGroup{}, c.ttl, nil
+	default:
+		err := &HTTPStatusError{StatusCode: resp.StatusCode, Header: resp.Header}
+		c.record(ctx, host, err)
+		return nil, robotsRetryTTL, err
+	}
+}
+
+func (c *robotsCache) record(ctx context.Context, host string, err error) {
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	c.breaker.Record(host, err)
+}
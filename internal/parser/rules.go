@@ -0,0 +1,240 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// Rule maps a shop (by host or URL pattern) to the places on its pages where
+// the price, currency and old price live. Rules are evaluated before the
+// generic heuristics.
+type Rule struct {
+	Name       string
+	Host       string
+	URLPattern string
+	Price      FieldRule
+	Currency   FieldRule
+	OldPrice   FieldRule
+}
+
+type FieldRule struct {
+	Selector string
+	Attr     string
+	Regex    string
+	Value    string
+}
+
+type compiledRule struct {
+	name     string
+	host     string
+	urlRe    *regexp.Regexp
+	price    compiledField
+	currency compiledField
+	oldPrice compiledField
+}
+
+type compiledField struct {
+	sel   cascadia.Sel
+	attr  string
+	re    *regexp.Regexp
+	value string
+}
+
+type ruleMatch struct {
+	rule     string
+	price    string
+	currency string
+	oldPrice string
+	// node is the element the price was taken from.
+	node *html.Node
+}
+
+func compileRule(r Rule) (compiledRule, error) {
+	name := r.Name
+	if name == "" {
+		name = firstNonEmptyString(r.Host, r.URLPattern)
+	}
+
+	cr := compiledRule{
+		name: name,
+		host: normalizeHost(r.Host),
+	}
+	if r.URLPattern != "" {
+		re, err := regexp.Compile(r.URLPattern)
+		if err != nil {
+			return compiledRule{}, fmt.Errorf("rule %q: url_pattern: %w", name, err)
+		}
+		cr.urlRe = re
+	}
+	if cr.host == "" && cr.urlRe == nil {
+		return compiledRule{}, fmt.Errorf("rule %q: host or url_pattern is required", name)
+	}
+	if r.Price.Selector == "" {
+		return compiledRule{}, fmt.Errorf("rule %q: price selector is required", name)
+	}
+
+	var err error
+	if cr.price, err = compileField(r.Price); err != nil {
+		return compiledRule{}, fmt.Errorf("rule %q: price: %w", name, err)
+	}
+	if cr.currency, err = compileField(r.Currency); err != nil {
+		return compiledRule{}, fmt.Errorf("rule %q: currency: %w", name, err)
+	}
+	if cr.oldPrice, err = compileField(r.OldPrice); err != nil {
+		return compiledRule{}, fmt.Errorf("rule %q: old_price: %w", name, err)
+	}
+
+	return cr, nil
+}
+
+func compileField(f FieldRule) (compiledField, error) {
+	cf := compiledField{
+		attr:  strings.TrimSpace(f.Attr),
+		value: strings.TrimSpace(f.Value),
+	}
+	if f.Selector != "" {
+		sel, err := cascadia.Parse(f.Selector)
+		if err != nil {
+			return compiledField{}, fmt.Errorf("selector: %w", err)
+		}
+		cf.sel = sel
+	}
+	if f.Regex != "" {
+		re, err := regexp.Compile(f.Regex)
+		if err != nil {
+			return compiledField{}, fmt.Errorf("regex: %w", err)
+		}
+		cf.re = re
+	}
+	return cf, nil
+}
+
+func (r *compiledRule) matches(u *url.URL, rawURL string) bool {
+	if r.urlRe != nil && r.urlRe.MatchString(rawURL) {
+		return true
+	}
+	if r.host == "" || u == nil {
+		return false
+	}
+	host := normalizeHost(u.Hostname())
+	return host == r.host || strings.HasSuffix(host, "."+r.host)
+}
+
+// extractWithRules runs the first rules matching pageURL against the page.
+// The document is parsed lazily, only when at least one rule applies.
+func extractWithRules(rules []compiledRule, pageURL string, b []byte) (ruleMatch, bool) {
+	if len(rules) == 0 || pageURL == "" {
+		return ruleMatch{}, false
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return ruleMatch{}, false
+	}
+
+	var doc *html.Node
+	for i := range rules {
+		r := &rules[i]
+		if !r.matches(u, pageURL) {
+			continue
+		}
+		if doc == nil {
+			doc, err = html.Parse(bytes.NewReader(b))
+			if err != nil {
+				return ruleMatch{}, false
+			}
+		}
+
+		price, ok := r.price.eval(doc)
+		if !ok {
+			continue
+		}
+		currency, _ := r.currency.eval(doc)
+		oldPrice, _ := r.oldPrice.eval(doc)
+		var node *html.Node
+		if r.price.sel != nil {
+			node = cascadia.Query(doc, r.price.sel)
+		}
+		return ruleMatch{
+			rule:     r.name,
+			price:    price,
+			currency: currency,
+			oldPrice: oldPrice,
+			node:     node,
+		}, true
+	}
+	return ruleMatch{}, false
+}
+
+func (f *compiledField) eval(doc *html.Node) (string, bool) {
+	if f.sel == nil {
+		return f.value, f.value != ""
+	}
+
+	n := cascadia.Query(doc, f.sel)
+	if n == nil {
+		return "", false
+	}
+
+	var v string
+	if f.attr != "" {
+		for _, a := range n.Attr {
+			if strings.EqualFold(a.Key, f.attr) {
+				v = a.Val
+				break
+			}
+		}
+	} else {
+		v = nodeText(n)
+	}
+	v = strings.TrimSpace(v)
+
+	if f.re != nil {
+		m := f.re.FindStringSubmatch(v)
+		if m == nil {
+			return "", false
+		}
+		v = m[0]
+		if len(m) > 1 {
+			v = m[1]
+		}
+		v = strings.TrimSpace(v)
+	}
+	return v, v != ""
+}
+
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func normalizeHost(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	return strings.TrimPrefix(h, "www.")
+}
+
+func firstNonEmptyString(v ...string) string {
+	for _, s := range v {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// priceKeys hold the price of an object in embedded JSON, in order of
+// preference. lowPrice is the price of a schema.org AggregateOffer.
+var priceKeys = []string{"price", "priceValue", "price_value", "priceNumeric", "price_num", "amount", "value", "lowPrice"}
+
+// priceScope is the part of the page the price was found in. Details of the
+// offer, such as its old price, are only looked up there, so another product
+// on the same page (a recommendation, a bundle, a filter) can not lend them.
+type priceScope struct {
+	// objects are the JSON object holding the price and, when it is listed
+	// in "offers", the product holding it.
+	objects []map[string]any
+	// container is the element around the price, nil when it is unknown.
+	container *html.Node
+}
+
+// priceContainerDepth is how many elements above the price its container may
+// be: <div><del>1 490</del> <span><b>990</b> ₽</span></div>.
+const priceContainerDepth = 2
+
+// findPriceScope locates the price of res on the page. node is the element a
+// rule took the price from.
+func findPriceScope(b []byte, res Result, node *html.Node) priceScope {
+	var scope priceScope
+	switch res.Strategy {
+	case StrategyJSONLD, StrategyScriptJSON:
+		for _, v := range scriptJSONs(b) {
+			if objs := priceObjects(v, res.Raw); objs != nil {
+				scope.objects = objs
+				break
+			}
+		}
+		return scope
+	case StrategyRule:
+	default:
+		doc, err := html.Parse(bytes.NewReader(b))
+		if err != nil {
+			return scope
+		}
+		node = findPriceNode(doc, res)
+	}
+	if node != nil {
+		scope.container = priceContainer(node)
+	}
+	return scope
+}
+
+// priceObjects returns the first object, by key, whose price is raw, and the
+// product listing it in "offers".
+func priceObjects(v any, raw string) []map[string]any {
+	switch x := v.(type) {
+	case map[string]any:
+		if p, ok := firstKey(x, priceKeys...); ok && toString(p) == raw {
+			return []map[string]any{x}
+		}
+		if objs := priceObjects(x["offers"], raw); objs != nil {
+			if len(objs) == 1 {
+				objs = append(objs, x)
+			}
+			return objs
+		}
+		for _, k := range sortedKeys(x) {
+			if k == "offers" {
+				continue
+			}
+			if objs := priceObjects(x[k], raw); objs != nil {
+				return objs
+			}
+		}
+	case []any:
+		for _, v2 := range x {
+			if objs := priceObjects(v2, raw); objs != nil {
+				return objs
+			}
+		}
+	}
+	return nil
+}
+
+// findPriceNode returns the meta tag holding the price, or the first visible
+// text containing it outside crossed-out elements.
+func findPriceNode(doc *html.Node, res Result) *html.Node {
+	if res.Strategy == StrategyMeta {
+		return findNode(doc, func(n *html.Node) bool {
+			if n.Type != html.ElementNode || n.DataAtom != atom.Meta {
+				return false
+			}
+			itemprop := strings.ToLower(strings.TrimSpace(attr(n, "itemprop")))
+			property := strings.ToLower(strings.TrimSpace(attr(n, "property")))
+			if itemprop != "price" && property != "product:price:amount" && property != "og:price:amount" {
+				return false
+			}
+			return strings.TrimSpace(attr(n, "content")) == res.Raw
+		})
+	}
+	return findNode(doc, func(n *html.Node) bool {
+		return n.Type == html.TextNode && strings.Contains(n.Data, res.Raw)
+	})
+}
+
+// findNode returns the first node matching, skipping scripts, styles and
+// crossed-out elements.
+func findNode(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if match(n) {
+		return n
+	}
+	if n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style || isStruck(n.Data, n.Attr)) {
+		return nil
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findNode(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// priceContainer climbs at most priceContainerDepth elements up from n. It
+// stops at an itemscope, which holds a whole product, and never returns the
+// body of the page.
+func priceContainer(n *html.Node) *html.Node {
+	if n.Type != html.ElementNode {
+		n = n.Parent
+	}
+	var container *html.Node
+	for depth := 0; n != nil && n.Type == html.ElementNode && depth <= priceContainerDepth; depth++ {
+		switch n.DataAtom {
+		case atom.Html, atom.Head, atom.Body:
+			return container
+		}
+		container = n
+		if hasAttr(n, "itemscope") {
+			break
+		}
+		n = n.Parent
+	}
+	return container
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
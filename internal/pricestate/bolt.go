@@ -0,0 +1,69 @@
+package pricestate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var publishedBucket = []byte("published")
+
+type boltRecord struct {
+	MetaHash    string    `json:"meta_hash"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// Bolt keeps the last published MetaHash per product in an embedded bbolt
+// database, so a restart does not re-publish every unchanged price.
+type Bolt struct {
+	db *bolt.DB
+}
+
+func OpenBolt(path string) (*Bolt, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create price state dir: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open price state: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(publishedBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("init price state: %w", err)
+	}
+	return &Bolt{db: db}, nil
+}
+
+func (b *Bolt) LastPublished(_ context.Context, key string) (string, time.Time, error) {
+	var r boltRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(publishedBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &r)
+	})
+	return r.MetaHash, r.PublishedAt, err
+}
+
+func (b *Bolt) SavePublished(_ context.Context, key, metaHash string, at time.Time) error {
+	v, err := json.Marshal(boltRecord{MetaHash: metaHash, PublishedAt: at})
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(publishedBucket).Put([]byte(key), v)
+	})
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,40 @@
+package pricestate
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type record struct {
+	metaHash    string
+	publishedAt time.Time
+}
+
+// Memory keeps the last published MetaHash per product in process memory.
+type Memory struct {
+	mu    sync.RWMutex
+	items map[string]record
+}
+
+func NewMemory() *Memory {
+	return &Memory{items: make(map[string]record)}
+}
+
+func (m *Memory) LastPublished(_ context.Context, key string) (string, time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r := m.items[key]
+	return r.metaHash, r.publishedAt, nil
+}
+
+func (m *Memory) SavePublished(_ context.Context, key, metaHash string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items[key] = record{metaHash: metaHash, publishedAt: at}
+	return nil
+}
+
+func (m *Memory) Close() error { return nil }
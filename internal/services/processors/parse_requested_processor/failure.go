@@ -0,0 +1,96 @@
+package parse_requested_processor
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/LehaAlexey/Parsing/internal/models/events"
+	"github.com/LehaAlexey/Parsing/internal/parser"
+)
+
+// Failure is returned by Handle when a request could not be turned into a
+// PriceMeasured event. Category is what gets published in ParseFailed.
+type Failure struct {
+	Category   events.FailureCategory
+	HTTPStatus int
+	Attempts   int
+	Err        error
+
+	published bool
+}
+
+func (f *Failure) Error() string { return f.Err.Error() }
+
+func (f *Failure) Unwrap() error { return f.Err }
+
+// Published reports whether the failure reached the ParseFailed topic. Callers
+// should retry or dead-letter requests whose failures were not published.
+func (f *Failure) Published() bool { return f.published }
+
+func asFailure(err error) *Failure {
+	var f *Failure
+	if errors.As(err, &f) {
+		return f
+	}
+	return &Failure{Category: events.FailureNetwork, Err: err}
+}
+
+func classifyFetchError(err error) *Failure {
+	f := &Failure{Category: events.FailureNetwork, Err: err}
+
+	var fetchErr *parser.FetchError
+	if errors.As(err, &fetchErr) {
+		f.Attempts = fetchErr.Attempts
+	}
+
+	var blockedErr *parser.BlockedError
+	if errors.As(err, &blockedErr) {
+		f.Category = events.FailureBlocked
+		f.HTTPStatus = blockedErr.StatusCode
+		return f
+	}
+
+	// robots.txt of a shop that is down: a network failure, not an opt-out
+	var robotsErr *parser.RobotsUnavailableError
+	if errors.As(err, &robotsErr) {
+		var statusErr *parser.HTTPStatusError
+		if errors.As(err, &statusErr) {
+			f.HTTPStatus = statusErr.StatusCode
+		}
+		return f
+	}
+
+	var disallowedErr *parser.DisallowedError
+	if errors.As(err, &disallowedErr) {
+		f.Category = events.FailureDisallowed
+		return f
+	}
+
+	// a URL of an internal host is a bad request, not an opt-out of the shop
+	var forbiddenErr *parser.ForbiddenAddressError
+	if errors.As(err, &forbiddenErr) {
+		f.Category = events.FailureInvalidRequest
+		return f
+	}
+
+	var tooLargeErr *parser.BodyTooLargeError
+	if errors.As(err, &tooLargeErr) {
+		f.Category = events.FailureTooLarge
+		return f
+	}
+
+	var statusErr *parser.HTTPStatusError
+	if errors.As(err, &statusErr) {
+		f.HTTPStatus = statusErr.StatusCode
+		switch statusErr.StatusCode {
+		case http.StatusNotFound, http.StatusGone:
+			f.Category = events.FailureNotFound
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests, http.StatusUnavailableForLegalReasons:
+			f.Category = events.FailureBlocked
+		default:
+			f.Category = events.FailureHTTPStatus
+		}
+	}
+
+	return f
+}
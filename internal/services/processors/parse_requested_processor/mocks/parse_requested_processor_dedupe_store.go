@@ -0,0 +1,140 @@
+// Code generated by mockery v2.53.5. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// MockDedupeStore is an autogenerated mock type for the DedupeStore type
+type MockDedupeStore struct {
+	mock.Mock
+}
+
+type MockDedupeStore_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *MockDedupeStore) EXPECT() *MockDedupeStore_Expecter {
+	return &MockDedupeStore_Expecter{mock: &_m.Mock}
+}
+
+// Mark provides a mock function with given fields: ctx, key
+func (_m *MockDedupeStore) Mark(ctx context.Context, key string) error {
+	ret := _m.Called(ctx, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Mark")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockDedupeStore_Mark_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Mark'
+type MockDedupeStore_Mark_Call struct {
+	*mock.Call
+}
+
+// Mark is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key string
+func (_e *MockDedupeStore_Expecter) Mark(ctx interface{}, key interface{}) *MockDedupeStore_Mark_Call {
+	return &MockDedupeStore_Mark_Call{Call: _e.mock.On("Mark", ctx, key)}
+}
+
+func (_c *MockDedupeStore_Mark_Call) Run(run func(ctx context.Context, key string)) *MockDedupeStore_Mark_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockDedupeStore_Mark_Call) Return(_a0 error) *MockDedupeStore_Mark_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockDedupeStore_Mark_Call) RunAndReturn(run func(context.Context, string) error) *MockDedupeStore_Mark_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Seen provides a mock function with given fields: ctx, key
+func (_m *MockDedupeStore) Seen(ctx context.Context, key string) (bool, error) {
+	ret := _m.Called(ctx, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Seen")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (bool, error)); ok {
+		return rf(ctx, key)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) bool); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockDedupeStore_Seen_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Seen'
+type MockDedupeStore_Seen_Call struct {
+	*mock.Call
+}
+
+// Seen is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key string
+func (_e *MockDedupeStore_Expecter) Seen(ctx interface{}, key interface{}) *MockDedupeStore_Seen_Call {
+	return &MockDedupeStore_Seen_Call{Call: _e.mock.On("Seen", ctx, key)}
+}
+
+func (_c *MockDedupeStore_Seen_Call) Run(run func(ctx context.Context, key string)) *MockDedupeStore_Seen_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockDedupeStore_Seen_Call) Return(_a0 bool, _a1 error) *MockDedupeStore_Seen_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockDedupeStore_Seen_Call) RunAndReturn(run func(context.Context, string) (bool, error)) *MockDedupeStore_Seen_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewMockDedupeStore creates a new instance of MockDedupeStore. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockDedupeStore(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockDedupeStore {
+	mock := &MockDedupeStore{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -2,7 +2,11 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	mock "github.com/stretchr/testify/mock"
+
+	parser "github.com/LehaAlexey/Parsing/internal/parser"
+)
 
 // MockExtractor is an autogenerated mock type for the Extractor type
 type MockExtractor struct {
@@ -17,39 +21,32 @@ func (_m *MockExtractor) EXPECT() *MockExtractor_Expecter {
 	return &MockExtractor_Expecter{mock: &_m.Mock}
 }
 
-// Extract provides a mock function with given fields: htmlBytes
-func (_m *MockExtractor) Extract(htmlBytes []byte) (int64, string, bool) {
-	ret := _m.Called(htmlBytes)
+// Extract provides a mock function with given fields: pageURL, htmlBytes
+func (_m *MockExtractor) Extract(pageURL string, htmlBytes []byte) (parser.Result, bool) {
+	ret := _m.Called(pageURL, htmlBytes)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Extract")
 	}
 
-	var r0 int64
-	var r1 string
-	var r2 bool
-	if rf, ok := ret.Get(0).(func([]byte) (int64, string, bool)); ok {
-		return rf(htmlBytes)
-	}
-	if rf, ok := ret.Get(0).(func([]byte) int64); ok {
-		r0 = rf(htmlBytes)
-	} else {
-		r0 = ret.Get(0).(int64)
+	var r0 parser.Result
+	var r1 bool
+	if rf, ok := ret.Get(0).(func(string, []byte) (parser.Result, bool)); ok {
+		return rf(pageURL, htmlBytes)
 	}
-
-	if rf, ok := ret.Get(1).(func([]byte) string); ok {
-		r1 = rf(htmlBytes)
+	if rf, ok := ret.Get(0).(func(string, []byte) parser.Result); ok {
+		r0 = rf(pageURL, htmlBytes)
 	} else {
-		r1 = ret.Get(1).(string)
+		r0 = ret.Get(0).(parser.Result)
 	}
 
-	if rf, ok := ret.Get(2).(func([]byte) bool); ok {
-		r2 = rf(htmlBytes)
+	if rf, ok := ret.Get(1).(func(string, []byte) bool); ok {
+		r1 = rf(pageURL, htmlBytes)
 	} else {
-		r2 = ret.Get(2).(bool)
+		r1 = ret.Get(1).(bool)
 	}
 
-	return r0, r1, r2
+	return r0, r1
 }
 
 // MockExtractor_Extract_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Extract'
@@ -58,24 +55,25 @@ type MockExtractor_Extract_Call struct {
 }
 
 // Extract is a helper method to define mock.On call
+//   - pageURL string
 //   - htmlBytes []byte
-func (_e *MockExtractor_Expecter) Extract(htmlBytes interface{}) *MockExtractor_Extract_Call {
-	return &MockExtractor_Extract_Call{Call: _e.mock.On("Extract", htmlBytes)}
+func (_e *MockExtractor_Expecter) Extract(pageURL interface{}, htmlBytes interface{}) *MockExtractor_Extract_Call {
+	return &MockExtractor_Extract_Call{Call: _e.mock.On("Extract", pageURL, htmlBytes)}
 }
 
-func (_c *MockExtractor_Extract_Call) Run(run func(htmlBytes []byte)) *MockExtractor_Extract_Call {
+func (_c *MockExtractor_Extract_Call) Run(run func(pageURL string, htmlBytes []byte)) *MockExtractor_Extract_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].([]byte))
+		run(args[0].(string), args[1].([]byte))
 	})
 	return _c
 }
 
-func (_c *MockExtractor_Extract_Call) Return(_a0 int64, _a1 string, _a2 bool) *MockExtractor_Extract_Call {
-	_c.Call.Return(_a0, _a1, _a2)
+func (_c *MockExtractor_Extract_Call) Return(_a0 parser.Result, _a1 bool) *MockExtractor_Extract_Call {
+	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *MockExtractor_Extract_Call) RunAndReturn(run func([]byte) (int64, string, bool)) *MockExtractor_Extract_Call {
+func (_c *MockExtractor_Extract_Call) RunAndReturn(run func(string, []byte) (parser.Result, bool)) *MockExtractor_Extract_Call {
 	_c.Call.Return(run)
 	return _c
 }
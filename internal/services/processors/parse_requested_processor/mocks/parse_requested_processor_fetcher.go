@@ -6,6 +6,8 @@ import (
 	context "context"
 
 	mock "github.com/stretchr/testify/mock"
+
+	parser "github.com/LehaAlexey/Parsing/internal/parser"
 )
 
 // MockFetcher is an autogenerated mock type for the Fetcher type
@@ -22,40 +24,33 @@ func (_m *MockFetcher) EXPECT() *MockFetcher_Expecter {
 }
 
 // Fetch provides a mock function with given fields: ctx, rawURL
-func (_m *MockFetcher) Fetch(ctx context.Context, rawURL string) ([]byte, string, error) {
+func (_m *MockFetcher) Fetch(ctx context.Context, rawURL string) (*parser.FetchResult, error) {
 	ret := _m.Called(ctx, rawURL)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Fetch")
 	}
 
-	var r0 []byte
-	var r1 string
-	var r2 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) ([]byte, string, error)); ok {
+	var r0 *parser.FetchResult
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*parser.FetchResult, error)); ok {
 		return rf(ctx, rawURL)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string) []byte); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, string) *parser.FetchResult); ok {
 		r0 = rf(ctx, rawURL)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]byte)
+			r0 = ret.Get(0).(*parser.FetchResult)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string) string); ok {
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
 		r1 = rf(ctx, rawURL)
 	} else {
-		r1 = ret.Get(1).(string)
-	}
-
-	if rf, ok := ret.Get(2).(func(context.Context, string) error); ok {
-		r2 = rf(ctx, rawURL)
-	} else {
-		r2 = ret.Error(2)
+		r1 = ret.Error(1)
 	}
 
-	return r0, r1, r2
+	return r0, r1
 }
 
 // MockFetcher_Fetch_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Fetch'
@@ -77,12 +72,12 @@ func (_c *MockFetcher_Fetch_Call) Run(run func(ctx context.Context, rawURL strin
 	return _c
 }
 
-func (_c *MockFetcher_Fetch_Call) Return(_a0 []byte, _a1 string, _a2 error) *MockFetcher_Fetch_Call {
-	_c.Call.Return(_a0, _a1, _a2)
+func (_c *MockFetcher_Fetch_Call) Return(_a0 *parser.FetchResult, _a1 error) *MockFetcher_Fetch_Call {
+	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *MockFetcher_Fetch_Call) RunAndReturn(run func(context.Context, string) ([]byte, string, error)) *MockFetcher_Fetch_Call {
+func (_c *MockFetcher_Fetch_Call) RunAndReturn(run func(context.Context, string) (*parser.FetchResult, error)) *MockFetcher_Fetch_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -0,0 +1,151 @@
+// Code generated by mockery v2.53.5. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+
+	time "time"
+)
+
+// MockStateStore is an autogenerated mock type for the StateStore type
+type MockStateStore struct {
+	mock.Mock
+}
+
+type MockStateStore_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *MockStateStore) EXPECT() *MockStateStore_Expecter {
+	return &MockStateStore_Expecter{mock: &_m.Mock}
+}
+
+// LastPublished provides a mock function with given fields: ctx, key
+func (_m *MockStateStore) LastPublished(ctx context.Context, key string) (string, time.Time, error) {
+	ret := _m.Called(ctx, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LastPublished")
+	}
+
+	var r0 string
+	var r1 time.Time
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (string, time.Time, error)); ok {
+		return rf(ctx, key)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) time.Time); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Get(1).(time.Time)
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, string) error); ok {
+		r2 = rf(ctx, key)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// MockStateStore_LastPublished_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'LastPublished'
+type MockStateStore_LastPublished_Call struct {
+	*mock.Call
+}
+
+// LastPublished is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key string
+func (_e *MockStateStore_Expecter) LastPublished(ctx interface{}, key interface{}) *MockStateStore_LastPublished_Call {
+	return &MockStateStore_LastPublished_Call{Call: _e.mock.On("LastPublished", ctx, key)}
+}
+
+func (_c *MockStateStore_LastPublished_Call) Run(run func(ctx context.Context, key string)) *MockStateStore_LastPublished_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *MockStateStore_LastPublished_Call) Return(_a0 string, _a1 time.Time, _a2 error) *MockStateStore_LastPublished_Call {
+	_c.Call.Return(_a0, _a1, _a2)
+	return _c
+}
+
+func (_c *MockStateStore_LastPublished_Call) RunAndReturn(run func(context.Context, string) (string, time.Time, error)) *MockStateStore_LastPublished_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SavePublished provides a mock function with given fields: ctx, key, metaHash, at
+func (_m *MockStateStore) SavePublished(ctx context.Context, key string, metaHash string, at time.Time) error {
+	ret := _m.Called(ctx, key, metaHash, at)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SavePublished")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) error); ok {
+		r0 = rf(ctx, key, metaHash, at)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockStateStore_SavePublished_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SavePublished'
+type MockStateStore_SavePublished_Call struct {
+	*mock.Call
+}
+
+// SavePublished is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key string
+//   - metaHash string
+//   - at time.Time
+func (_e *MockStateStore_Expecter) SavePublished(ctx interface{}, key interface{}, metaHash interface{}, at interface{}) *MockStateStore_SavePublished_Call {
+	return &MockStateStore_SavePublished_Call{Call: _e.mock.On("SavePublished", ctx, key, metaHash, at)}
+}
+
+func (_c *MockStateStore_SavePublished_Call) Run(run func(ctx context.Context, key string, metaHash string, at time.Time)) *MockStateStore_SavePublished_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string), args[3].(time.Time))
+	})
+	return _c
+}
+
+func (_c *MockStateStore_SavePublished_Call) Return(_a0 error) *MockStateStore_SavePublished_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockStateStore_SavePublished_Call) RunAndReturn(run func(context.Context, string, string, time.Time) error) *MockStateStore_SavePublished_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewMockStateStore creates a new instance of MockStateStore. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockStateStore(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockStateStore {
+	mock := &MockStateStore{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -5,38 +5,99 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"strconv"
+	"math"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	currencies "github.com/LehaAlexey/Parsing/internal/currency"
 	"github.com/LehaAlexey/Parsing/internal/kafka"
 	"github.com/LehaAlexey/Parsing/internal/models"
 	"github.com/LehaAlexey/Parsing/internal/models/events"
+	"github.com/LehaAlexey/Parsing/internal/parser"
 	kafkago "github.com/segmentio/kafka-go"
 )
 
 type Extractor interface {
-	Extract(htmlBytes []byte) (int64, string, bool)
+	Extract(pageURL string, htmlBytes []byte) (parser.Result, bool)
 }
 
 type Fetcher interface {
-	Fetch(ctx context.Context, rawURL string) ([]byte, string, error)
+	Fetch(ctx context.Context, rawURL string) (*parser.FetchResult, error)
+}
+
+// DedupeStore remembers completed requests by event ID.
+type DedupeStore interface {
+	Seen(ctx context.Context, key string) (bool, error)
+	Mark(ctx context.Context, key string) error
+}
+
+// StateStore remembers the last published MetaHash per product (or URL).
+// An empty hash means nothing was published yet.
+type StateStore interface {
+	LastPublished(ctx context.Context, key string) (string, time.Time, error)
+	SavePublished(ctx context.Context, key, metaHash string, at time.Time) error
+}
+
+type Config struct {
+	// MaxStaleness skips requests whose ScheduledAt (or OccurredAt) is older
+	// than this; zero disables the check.
+	MaxStaleness time.Duration
+	// Heartbeat is used with WithStateStore: an unchanged price is published
+	// again once this much time passed since the last publish; zero means
+	// never.
+	Heartbeat time.Duration
 }
 
 type Processor struct {
-	extractor Extractor
-	fetcher   Fetcher
-	writer    kafka.Writer
+	cfg          Config
+	extractor    Extractor
+	fetcher      Fetcher
+	writer       kafka.Writer
+	failedWriter kafka.Writer
+	dedupe       DedupeStore
+	state        StateStore
+	suppressed   atomic.Uint64
+}
+
+type Option func(*Processor)
+
+// WithDedupe makes Handle skip requests whose EventID was already completed,
+// unless the request is forced.
+func WithDedupe(store DedupeStore) Option {
+	return func(p *Processor) { p.dedupe = store }
+}
+
+// WithStateStore switches to publish-on-change mode: PriceMeasured is only
+// published when MetaHash differs from the last published one, or when the
+// heartbeat interval has passed.
+func WithStateStore(store StateStore) Option {
+	return func(p *Processor) { p.state = store }
 }
 
-func New(extractor Extractor, fetcher Fetcher, writer kafka.Writer) *Processor {
-	return &Processor{extractor: extractor, fetcher: fetcher, writer: writer}
+func New(cfg Config, extractor Extractor, fetcher Fetcher, writer kafka.Writer, failedWriter kafka.Writer, opts ...Option) *Processor {
+	p := &Processor{cfg: cfg, extractor: extractor, fetcher: fetcher, writer: writer, failedWriter: failedWriter}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
+// Handle parses the requested page and publishes PriceMeasured. Any failure is
+// also published as ParseFailed, so the scheduler learns about broken listings.
 func (p *Processor) Handle(ctx context.Context, req *events.ParseRequested) error {
-	req.URL = strings.TrimSpace(req.URL)
-	if req.URL == "" {
-		return fmt.Errorf("empty url")
+	dedupeKey := ""
+	if p.dedupe != nil && req.EventID != "" {
+		dedupeKey = "ParseRequested|" + req.EventID
+		if !req.Force && p.seen(ctx, dedupeKey) {
+			slog.Info("duplicate parse request skipped",
+				"event_id", req.EventID,
+				"product_id", req.ProductID,
+				"url", req.URL,
+				"correlation_id", req.CorrelationID,
+			)
+			return nil
+		}
 	}
 
 	if req.EventID == "" {
@@ -46,60 +107,307 @@ func (p *Processor) Handle(ctx context.Context, req *events.ParseRequested) erro
 		req.CorrelationID = req.EventID
 	}
 
-	body, finalURL, err := p.fetcher.Fetch(ctx, req.URL)
+	err := p.handle(ctx, req)
+	if err == nil {
+		p.markDone(ctx, dedupeKey, req)
+		return nil
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+
+	f := asFailure(err)
+	if werr := p.publishFailed(ctx, req, f); werr != nil {
+		return fmt.Errorf("%w; parse_failed write: %v", f, werr)
+	}
+	// a published failure finishes the request as much as a price does: a
+	// redelivery must not publish the same ParseFailed again
+	p.markDone(ctx, dedupeKey, req)
+	f.published = true
+	return f
+}
+
+func (p *Processor) markDone(ctx context.Context, dedupeKey string, req *events.ParseRequested) {
+	if dedupeKey == "" {
+		return
+	}
+	if err := p.dedupe.Mark(ctx, dedupeKey); err != nil {
+		slog.Error("dedupe mark", "error", err.Error(), "event_id", req.EventID)
+	}
+}
+
+func (p *Processor) handle(ctx context.Context, req *events.ParseRequested) error {
+	if err := p.checkStaleness(req); err != nil {
+		return err
+	}
+	m, err := p.Measure(ctx, req)
+	if err != nil {
+		return err
+	}
+	_, err = p.Publish(ctx, m)
+	return err
+}
+
+// Measurement is a parsed price ready to be published.
+type Measurement struct {
+	Event    events.PriceMeasured
+	Result   parser.Result
+	FinalURL string
+	// Fetch describes the response the price was extracted from.
+	Fetch   *parser.FetchResult
+	Timings Timings
+}
+
+type Timings struct {
+	Fetch   time.Duration
+	Extract time.Duration
+	Total   time.Duration
+}
+
+// Measure fetches the page and extracts the price without publishing
+// anything. Errors are *Failure where the category is known.
+func (p *Processor) Measure(ctx context.Context, req *events.ParseRequested) (*Measurement, error) {
+	start := time.Now()
+	req.URL = strings.TrimSpace(req.URL)
+	if req.URL == "" {
+		return nil, &Failure{Category: events.FailureInvalidRequest, Err: fmt.Errorf("empty url")}
+	}
+
+	fetch, err := p.fetcher.Fetch(ctx, req.URL)
 	if err != nil {
-		return fmt.Errorf("fetch: %w", err)
+		return nil, classifyFetchError(fmt.Errorf("fetch: %w", err))
 	}
+	fetched := time.Now()
+	finalURL := fetch.FinalURL
 
-	price, currency, ok := p.extractor.Extract(body)
+	res, ok := p.extractor.Extract(firstNonEmpty(finalURL, req.URL), fetch.Body)
 	if !ok {
-		return fmt.Errorf("price not found")
+		if fetch.Truncated {
+			return nil, &Failure{Category: events.FailureExtraction, Err: fmt.Errorf("price not found in truncated body (%d bytes)", len(fetch.Body))}
+		}
+		return nil, &Failure{Category: events.FailureExtraction, Err: fmt.Errorf("price not found")}
 	}
+	currency := res.Currency
 	if currency == "" {
 		currency = "RUB"
 	}
+	exp, _ := currencies.Exponent(currency)
+	minor, ok := res.Amount.Rescale(exp)
+	if !ok {
+		return nil, &Failure{Category: events.FailureExtraction, Err: fmt.Errorf("price %s out of range", res.Amount)}
+	}
+	// the normalised amount keeps the hash of a whole price as it was before
+	// minor units were published
+	hashed := firstNonEmpty(finalURL, req.URL) + "|" + res.Amount.Normalize().String() + "|" + currency
+	var oldMinor int64
+	var discount float64
+	if res.OldPrice.Value > 0 {
+		if oldMinor, ok = res.OldPrice.Rescale(exp); ok && oldMinor > minor {
+			discount = math.Round(float64(oldMinor-minor)/float64(oldMinor)*1000) / 10
+			hashed += "|" + res.OldPrice.Normalize().String()
+		} else {
+			oldMinor = 0
+		}
+	}
+	availability := res.Availability
+	if availability == "" {
+		availability = parser.AvailabilityUnknown
+	}
+	// hashes of products in stock or of unknown availability stay as they were
+	if availability != parser.AvailabilityInStock && availability != parser.AvailabilityUnknown {
+		hashed += "|" + string(availability)
+	}
+	metaHash := models.Sha256Hex(hashed)
+	extracted := time.Now()
 
-	parsedAt := time.Now().UTC()
-	pm := events.PriceMeasured{
-		EventID:       models.Sha256Hex("PriceMeasured|" + req.EventID),
-		OccurredAt:    parsedAt,
-		CorrelationID: req.CorrelationID,
-		ProductID:     req.ProductID,
-		Price:         price,
-		Currency:      currency,
-		ParsedAt:      parsedAt,
-		SourceURL:     firstNonEmpty(finalURL, req.URL),
-		MetaHash:      models.Sha256Hex(firstNonEmpty(finalURL, req.URL) + "|" + strconv.FormatInt(price, 10) + "|" + currency),
+	parsedAt := extracted.UTC()
+	m := &Measurement{
+		Event: events.PriceMeasured{
+			SchemaVersion: events.PriceMeasuredSchemaVersion,
+			EventID:       models.Sha256Hex("PriceMeasured|" + req.EventID),
+			OccurredAt:    parsedAt,
+			CorrelationID: req.CorrelationID,
+			ProductID:     req.ProductID,
+			Price:         res.Amount.Round(),
+			PriceMinor:    minor,
+			PriceExponent: exp,
+			PriceDecimal:  res.Amount.Format(exp),
+			Currency:      currency,
+			ParsedAt:      parsedAt,
+			SourceURL:     firstNonEmpty(finalURL, req.URL),
+			MetaHash:      metaHash,
+			Strategy:      string(res.Strategy),
+			RawPrice:      res.Raw,
+			Confidence:    string(res.Confidence),
+			Availability:  string(availability),
+		},
+		Result:   res,
+		FinalURL: finalURL,
+		Fetch:    fetch,
+		Timings: Timings{
+			Fetch:   fetched.Sub(start),
+			Extract: extracted.Sub(fetched),
+			Total:   extracted.Sub(start),
+		},
+	}
+	if oldMinor > 0 {
+		m.Event.OldPriceMinor = oldMinor
+		m.Event.OldPriceDecimal = res.OldPrice.Format(exp)
+		m.Event.DiscountPercent = discount
+		m.Event.OnSale = true
 	}
+	return m, nil
+}
 
-	payload, err := json.Marshal(&pm)
+// Publish writes the measurement as PriceMeasured. In publish-on-change mode an
+// unchanged price is skipped and Publish returns false.
+func (p *Processor) Publish(ctx context.Context, m *Measurement) (bool, error) {
+	pm := &m.Event
+	payload, err := json.Marshal(pm)
 	if err != nil {
-		return fmt.Errorf("marshal price_measured: %w", err)
+		return false, fmt.Errorf("marshal price_measured: %w", err)
 	}
 
-	key := req.ProductID
-	if key == "" {
-		key = models.Sha256Hex(pm.SourceURL)
+	key := messageKey(pm.ProductID, pm.SourceURL)
+	if p.unchanged(ctx, key, pm.MetaHash, pm.ParsedAt) {
+		total := p.suppressed.Add(1)
+		slog.Info("unchanged price not published",
+			"product_id", pm.ProductID,
+			"price", pm.Price,
+			"currency", pm.Currency,
+			"url", pm.SourceURL,
+			"correlation_id", pm.CorrelationID,
+			"suppressed_total", total,
+		)
+		return false, nil
 	}
 
 	if err := p.writer.WriteMessages(ctx, kafkago.Message{
 		Key:   []byte(key),
 		Value: payload,
 	}); err != nil {
-		return fmt.Errorf("kafka write: %w", err)
+		return false, &Failure{Category: events.FailurePublish, Err: fmt.Errorf("kafka write: %w", err)}
+	}
+	if p.state != nil {
+		if err := p.state.SavePublished(ctx, key, pm.MetaHash, pm.ParsedAt); err != nil {
+			slog.Error("price state save", "error", err.Error(), "key", key)
+		}
 	}
 
 	slog.Info("price measured published",
-		"product_id", req.ProductID,
-		"price", price,
-		"currency", currency,
+		"product_id", pm.ProductID,
+		"price", pm.Price,
+		"currency", pm.Currency,
+		"strategy", m.Result.Strategy,
+		"rule", m.Result.Rule,
+		"raw_price", m.Result.Raw,
+		"confidence", m.Result.Confidence,
+		"availability", pm.Availability,
 		"url", pm.SourceURL,
 		"correlation_id", pm.CorrelationID,
 	)
 
+	return true, nil
+}
+
+// Suppressed returns how many unchanged measurements were not published.
+func (p *Processor) Suppressed() uint64 {
+	return p.suppressed.Load()
+}
+
+// unchanged reports whether the measurement repeats the last published one
+// within the heartbeat interval. Store errors fail open.
+func (p *Processor) unchanged(ctx context.Context, key, metaHash string, now time.Time) bool {
+	if p.state == nil {
+		return false
+	}
+	last, at, err := p.state.LastPublished(ctx, key)
+	if err != nil {
+		slog.Error("price state lookup", "error", err.Error(), "key", key)
+		return false
+	}
+	if last != metaHash {
+		return false
+	}
+	return p.cfg.Heartbeat <= 0 || now.Sub(at) < p.cfg.Heartbeat
+}
+
+// seen fails open: a broken dedupe store must not stop parsing.
+func (p *Processor) seen(ctx context.Context, key string) bool {
+	ok, err := p.dedupe.Seen(ctx, key)
+	if err != nil {
+		slog.Error("dedupe lookup", "error", err.Error(), "key", key)
+		return false
+	}
+	return ok
+}
+
+func (p *Processor) checkStaleness(req *events.ParseRequested) error {
+	if p.cfg.MaxStaleness <= 0 {
+		return nil
+	}
+	requestedAt := req.ScheduledAt
+	if requestedAt.IsZero() {
+		requestedAt = req.OccurredAt
+	}
+	if requestedAt.IsZero() {
+		return nil
+	}
+	if age := time.Since(requestedAt); age > p.cfg.MaxStaleness {
+		return &Failure{
+			Category: events.FailureExpired,
+			Err:      fmt.Errorf("request expired: requested at %s, %s ago, staleness window %s", requestedAt.UTC().Format(time.RFC3339), age.Round(time.Second), p.cfg.MaxStaleness),
+		}
+	}
 	return nil
 }
 
+func (p *Processor) publishFailed(ctx context.Context, req *events.ParseRequested, f *Failure) error {
+	pf := events.ParseFailed{
+		EventID:        models.Sha256Hex("ParseFailed|" + req.EventID),
+		OccurredAt:     time.Now().UTC(),
+		CorrelationID:  req.CorrelationID,
+		RequestEventID: req.EventID,
+		ProductID:      req.ProductID,
+		URL:            req.URL,
+		Category:       f.Category,
+		Reason:         f.Error(),
+		HTTPStatus:     f.HTTPStatus,
+		Attempts:       f.Attempts,
+	}
+
+	payload, err := json.Marshal(&pf)
+	if err != nil {
+		return fmt.Errorf("marshal parse_failed: %w", err)
+	}
+
+	if err := p.failedWriter.WriteMessages(ctx, kafkago.Message{
+		Key:   []byte(messageKey(req.ProductID, req.URL)),
+		Value: payload,
+	}); err != nil {
+		return err
+	}
+
+	slog.Warn("parse failed published",
+		"product_id", req.ProductID,
+		"url", req.URL,
+		"category", pf.Category,
+		"http_status", pf.HTTPStatus,
+		"attempts", pf.Attempts,
+		"reason", pf.Reason,
+		"correlation_id", pf.CorrelationID,
+	)
+
+	return nil
+}
+
+func messageKey(productID, url string) string {
+	if productID != "" {
+		return productID
+	}
+	return models.Sha256Hex(url)
+}
+
 func firstNonEmpty(v ...string) string {
 	for _, s := range v {
 		if strings.TrimSpace(s) != "" {
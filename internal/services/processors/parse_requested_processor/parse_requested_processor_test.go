@@ -4,12 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
+	kafkaMocks "github.com/LehaAlexey/Parsing/internal/kafka/mocks"
 	"github.com/LehaAlexey/Parsing/internal/models"
 	"github.com/LehaAlexey/Parsing/internal/models/events"
+	"github.com/LehaAlexey/Parsing/internal/parser"
 	parse_requested_processor "github.com/LehaAlexey/Parsing/internal/services/processors/parse_requested_processor"
 	processorMocks "github.com/LehaAlexey/Parsing/internal/services/processors/parse_requested_processor/mocks"
-	kafkaMocks "github.com/LehaAlexey/Parsing/internal/kafka/mocks"
 	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -21,13 +23,20 @@ func TestHandle_Success(t *testing.T) {
 	extractor := processorMocks.NewMockExtractor(t)
 	fetcher := processorMocks.NewMockFetcher(t)
 	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
 
 	fetcher.EXPECT().
 		Fetch(mock.Anything, "https://example.com").
-		Return([]byte("<html></html>"), "https://final.example.com", nil)
+		Return(&parser.FetchResult{Body: []byte("<html></html>"), FinalURL: "https://final.example.com"}, nil)
 	extractor.EXPECT().
-		Extract([]byte("<html></html>")).
-		Return(int64(12345), "USD", true)
+		Extract("https://final.example.com", []byte("<html></html>")).
+		Return(parser.Result{
+			Amount:     parser.Decimal{Value: 12345},
+			Currency:   "USD",
+			Strategy:   parser.StrategyJSONLD,
+			Raw:        "12 345",
+			Confidence: parser.ConfidenceHigh,
+		}, true)
 	writer.EXPECT().
 		WriteMessages(mock.Anything, mock.Anything).
 		Run(func(_ context.Context, msgs ...kafka.Message) {
@@ -43,12 +52,15 @@ func TestHandle_Success(t *testing.T) {
 			require.Equal(t, "USD", pm.Currency)
 			require.Equal(t, "https://final.example.com", pm.SourceURL)
 			require.Equal(t, models.Sha256Hex("https://final.example.com|12345|USD"), pm.MetaHash)
+			require.Equal(t, "json_ld", pm.Strategy)
+			require.Equal(t, "12 345", pm.RawPrice)
+			require.Equal(t, "high", pm.Confidence)
 			require.False(t, pm.OccurredAt.IsZero())
 			require.True(t, pm.OccurredAt.Equal(pm.ParsedAt))
 		}).
 		Return(nil)
 
-	processor := parse_requested_processor.New(extractor, fetcher, writer)
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter)
 
 	req := &events.ParseRequested{
 		EventID:       "evt-1",
@@ -59,19 +71,149 @@ func TestHandle_Success(t *testing.T) {
 	require.NoError(t, processor.Handle(context.Background(), req))
 }
 
+// measure runs Measure on a page the extractor reads as res.
+func measure(t *testing.T, res parser.Result) events.PriceMeasured {
+	t.Helper()
+
+	extractor := processorMocks.NewMockExtractor(t)
+	fetcher := processorMocks.NewMockFetcher(t)
+	fetcher.EXPECT().
+		Fetch(mock.Anything, "https://example.com/item").
+		Return(&parser.FetchResult{Body: []byte("<html></html>"), FinalURL: "https://example.com/item"}, nil)
+	extractor.EXPECT().
+		Extract("https://example.com/item", []byte("<html></html>")).
+		Return(res, true)
+
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, kafkaMocks.NewMockWriter(t), kafkaMocks.NewMockWriter(t))
+	m, err := processor.Measure(context.Background(), &events.ParseRequested{EventID: "evt-1", URL: "https://example.com/item"})
+	require.NoError(t, err)
+	return m.Event
+}
+
+func TestMeasure_MinorUnits(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		res         parser.Result
+		wantPrice   int64
+		wantMinor   int64
+		wantExp     int
+		wantDecimal string
+		wantHash    string
+	}{
+		{
+			name:        "whole price",
+			res:         parser.Result{Amount: parser.Decimal{Value: 12345}, Currency: "USD"},
+			wantPrice:   12345,
+			wantMinor:   1234500,
+			wantExp:     2,
+			wantDecimal: "12345.00",
+			wantHash:    "https://example.com/item|12345|USD",
+		},
+		{
+			name:        "fractional price rounds half up",
+			res:         parser.Result{Amount: parser.Decimal{Value: 1234550, Scale: 2}, Currency: "USD", Raw: "12 345.50"},
+			wantPrice:   12346,
+			wantMinor:   1234550,
+			wantExp:     2,
+			wantDecimal: "12345.50",
+			wantHash:    "https://example.com/item|12345.5|USD",
+		},
+		{
+			name:        "fractional price rounds down",
+			res:         parser.Result{Amount: parser.Decimal{Value: 9949, Scale: 2}, Currency: "RUB"},
+			wantPrice:   99,
+			wantMinor:   9949,
+			wantExp:     2,
+			wantDecimal: "99.49",
+			wantHash:    "https://example.com/item|99.49|RUB",
+		},
+		{
+			name:        "currency without minor units",
+			res:         parser.Result{Amount: parser.Decimal{Value: 1500}, Currency: "JPY"},
+			wantPrice:   1500,
+			wantMinor:   1500,
+			wantExp:     0,
+			wantDecimal: "1500",
+			wantHash:    "https://example.com/item|1500|JPY",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			pm := measure(t, tc.res)
+			require.Equal(t, tc.res.Price(), pm.Price)
+			require.Equal(t, tc.wantPrice, pm.Price)
+			require.Equal(t, tc.wantMinor, pm.PriceMinor)
+			require.Equal(t, tc.wantExp, pm.PriceExponent)
+			require.Equal(t, tc.wantDecimal, pm.PriceDecimal)
+			require.Equal(t, models.Sha256Hex(tc.wantHash), pm.MetaHash)
+		})
+	}
+}
+
+func TestMeasure_OldPrice(t *testing.T) {
+	t.Parallel()
+
+	pm := measure(t, parser.Result{
+		Amount:   parser.Decimal{Value: 1234550, Scale: 2},
+		OldPrice: parser.Decimal{Value: 15000},
+		Currency: "USD",
+	})
+	require.Equal(t, int64(1500000), pm.OldPriceMinor)
+	require.Equal(t, "15000.00", pm.OldPriceDecimal)
+	require.Equal(t, 17.7, pm.DiscountPercent)
+	require.True(t, pm.OnSale)
+	require.Equal(t, models.Sha256Hex("https://example.com/item|12345.5|USD|15000"), pm.MetaHash)
+
+	// an old price not above the current one is no discount
+	pm = measure(t, parser.Result{Amount: parser.Decimal{Value: 100}, OldPrice: parser.Decimal{Value: 90}, Currency: "USD"})
+	require.False(t, pm.OnSale)
+	require.Zero(t, pm.OldPriceMinor)
+	require.Equal(t, models.Sha256Hex("https://example.com/item|100|USD"), pm.MetaHash)
+}
+
+func TestMeasure_Availability(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		availability parser.Availability
+		want         string
+		wantHash     string
+	}{
+		{availability: "", want: "unknown", wantHash: "https://example.com/item|10|RUB"},
+		{availability: parser.AvailabilityInStock, want: "in_stock", wantHash: "https://example.com/item|10|RUB"},
+		{availability: parser.AvailabilityOutOfStock, want: "out_of_stock", wantHash: "https://example.com/item|10|RUB|out_of_stock"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.want, func(t *testing.T) {
+			t.Parallel()
+
+			pm := measure(t, parser.Result{Amount: parser.Decimal{Value: 10}, Currency: "RUB", Availability: tc.availability})
+			require.Equal(t, tc.want, pm.Availability)
+			require.Equal(t, models.Sha256Hex(tc.wantHash), pm.MetaHash)
+		})
+	}
+}
+
 func TestHandle_DefaultCurrencyAndKey(t *testing.T) {
 	t.Parallel()
 
 	extractor := processorMocks.NewMockExtractor(t)
 	fetcher := processorMocks.NewMockFetcher(t)
 	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
 
 	fetcher.EXPECT().
 		Fetch(mock.Anything, "https://example.com/item").
-		Return([]byte("<html></html>"), "https://example.com/item", nil)
+		Return(&parser.FetchResult{Body: []byte("<html></html>"), FinalURL: "https://example.com/item"}, nil)
 	extractor.EXPECT().
-		Extract([]byte("<html></html>")).
-		Return(int64(99), "", true)
+		Extract("https://example.com/item", []byte("<html></html>")).
+		Return(parser.Result{Amount: parser.Decimal{Value: 99}, Strategy: parser.StrategyPriceRegex, Confidence: parser.ConfidenceLow}, true)
 	writer.EXPECT().
 		WriteMessages(mock.Anything, mock.Anything).
 		Run(func(_ context.Context, msgs ...kafka.Message) {
@@ -82,10 +224,15 @@ func TestHandle_DefaultCurrencyAndKey(t *testing.T) {
 			require.NoError(t, json.Unmarshal(msgs[0].Value, &pm))
 			require.Equal(t, "RUB", pm.Currency)
 			require.Equal(t, models.Sha256Hex("https://example.com/item|99|RUB"), pm.MetaHash)
+			require.False(t, pm.OnSale)
+			require.Zero(t, pm.OldPriceMinor)
+			require.Equal(t, "unknown", pm.Availability)
+			require.Equal(t, "price_regex", pm.Strategy)
+			require.Equal(t, "low", pm.Confidence)
 		}).
 		Return(nil)
 
-	processor := parse_requested_processor.New(extractor, fetcher, writer)
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter)
 
 	req := &events.ParseRequested{
 		EventID:       "evt-2",
@@ -101,8 +248,11 @@ func TestHandle_EmptyURL(t *testing.T) {
 	extractor := processorMocks.NewMockExtractor(t)
 	fetcher := processorMocks.NewMockFetcher(t)
 	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
+
+	expectParseFailed(t, failedWriter, events.FailureInvalidRequest, 0)
 
-	processor := parse_requested_processor.New(extractor, fetcher, writer)
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter)
 
 	err := processor.Handle(context.Background(), &events.ParseRequested{
 		URL: "   ",
@@ -111,7 +261,7 @@ func TestHandle_EmptyURL(t *testing.T) {
 	require.Contains(t, err.Error(), "empty url")
 
 	fetcher.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
-	extractor.AssertNotCalled(t, "Extract", mock.Anything)
+	extractor.AssertNotCalled(t, "Extract", mock.Anything, mock.Anything)
 	writer.AssertNotCalled(t, "WriteMessages", mock.Anything, mock.Anything)
 }
 
@@ -121,12 +271,14 @@ func TestHandle_FetchError(t *testing.T) {
 	extractor := processorMocks.NewMockExtractor(t)
 	fetcher := processorMocks.NewMockFetcher(t)
 	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
 
 	fetcher.EXPECT().
 		Fetch(mock.Anything, "https://example.com").
-		Return(nil, "", assertError("boom"))
+		Return(nil, assertError("boom"))
+	expectParseFailed(t, failedWriter, events.FailureNetwork, 0)
 
-	processor := parse_requested_processor.New(extractor, fetcher, writer)
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter)
 
 	err := processor.Handle(context.Background(), &events.ParseRequested{
 		URL: "https://example.com",
@@ -134,7 +286,7 @@ func TestHandle_FetchError(t *testing.T) {
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "fetch:")
 
-	extractor.AssertNotCalled(t, "Extract", mock.Anything)
+	extractor.AssertNotCalled(t, "Extract", mock.Anything, mock.Anything)
 	writer.AssertNotCalled(t, "WriteMessages", mock.Anything, mock.Anything)
 }
 
@@ -144,15 +296,17 @@ func TestHandle_PriceNotFound(t *testing.T) {
 	extractor := processorMocks.NewMockExtractor(t)
 	fetcher := processorMocks.NewMockFetcher(t)
 	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
 
 	fetcher.EXPECT().
 		Fetch(mock.Anything, "https://example.com").
-		Return([]byte("<html></html>"), "https://example.com", nil)
+		Return(&parser.FetchResult{Body: []byte("<html></html>"), FinalURL: "https://example.com"}, nil)
 	extractor.EXPECT().
-		Extract([]byte("<html></html>")).
-		Return(int64(0), "", false)
+		Extract("https://example.com", []byte("<html></html>")).
+		Return(parser.Result{}, false)
+	expectParseFailed(t, failedWriter, events.FailureExtraction, 0)
 
-	processor := parse_requested_processor.New(extractor, fetcher, writer)
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter)
 
 	err := processor.Handle(context.Background(), &events.ParseRequested{
 		URL: "https://example.com",
@@ -163,6 +317,387 @@ func TestHandle_PriceNotFound(t *testing.T) {
 	writer.AssertNotCalled(t, "WriteMessages", mock.Anything, mock.Anything)
 }
 
+func TestHandle_HTTPStatusCategories(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		status   int
+		category events.FailureCategory
+	}{
+		{404, events.FailureNotFound},
+		{410, events.FailureNotFound},
+		{403, events.FailureBlocked},
+		{429, events.FailureBlocked},
+		{500, events.FailureHTTPStatus},
+	}
+	for _, tc := range cases {
+		extractor := processorMocks.NewMockExtractor(t)
+		fetcher := processorMocks.NewMockFetcher(t)
+		writer := kafkaMocks.NewMockWriter(t)
+		failedWriter := kafkaMocks.NewMockWriter(t)
+
+		fetcher.EXPECT().
+			Fetch(mock.Anything, "https://example.com").
+			Return(nil, &parser.FetchError{Attempts: 4, Err: &parser.HTTPStatusError{StatusCode: tc.status}})
+		expectParseFailed(t, failedWriter, tc.category, tc.status)
+
+		processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter)
+
+		err := processor.Handle(context.Background(), &events.ParseRequested{
+			EventID:   "evt-3",
+			ProductID: "product-3",
+			URL:       "https://example.com",
+		})
+		require.Error(t, err)
+
+		var failure *parse_requested_processor.Failure
+		require.ErrorAs(t, err, &failure)
+		require.Equal(t, tc.category, failure.Category)
+		require.Equal(t, 4, failure.Attempts)
+		require.True(t, failure.Published())
+	}
+}
+
+func TestHandle_FetchErrorCategories(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		err      error
+		category events.FailureCategory
+		status   int
+	}{
+		{"robots rule", &parser.DisallowedError{URL: "https://example.com"}, events.FailureDisallowed, 0},
+		{"robots unreachable", &parser.RobotsUnavailableError{URL: "https://example.com", Err: &parser.HTTPStatusError{StatusCode: 503}}, events.FailureNetwork, 503},
+		{"robots timeout", &parser.RobotsUnavailableError{URL: "https://example.com", Err: &parser.NetworkError{Kind: parser.NetworkTimeout}}, events.FailureNetwork, 0},
+		{"internal address", &parser.ForbiddenAddressError{Addr: "10.0.0.1"}, events.FailureInvalidRequest, 0},
+	}
+	for _, tc := range cases {
+		extractor := processorMocks.NewMockExtractor(t)
+		fetcher := processorMocks.NewMockFetcher(t)
+		writer := kafkaMocks.NewMockWriter(t)
+		failedWriter := kafkaMocks.NewMockWriter(t)
+
+		fetcher.EXPECT().
+			Fetch(mock.Anything, "https://example.com").
+			Return(nil, &parser.FetchError{Attempts: 1, Err: tc.err})
+		expectParseFailed(t, failedWriter, tc.category, tc.status)
+
+		processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter)
+
+		err := processor.Handle(context.Background(), &events.ParseRequested{
+			EventID:   "evt-3",
+			ProductID: "product-3",
+			URL:       "https://example.com",
+		})
+		require.Error(t, err)
+
+		var failure *parse_requested_processor.Failure
+		require.ErrorAs(t, err, &failure, tc.name)
+		require.Equal(t, tc.category, failure.Category, tc.name)
+	}
+}
+
+func TestHandle_PublishErrorReported(t *testing.T) {
+	t.Parallel()
+
+	extractor := processorMocks.NewMockExtractor(t)
+	fetcher := processorMocks.NewMockFetcher(t)
+	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
+
+	fetcher.EXPECT().
+		Fetch(mock.Anything, "https://example.com").
+		Return(&parser.FetchResult{Body: []byte("<html></html>"), FinalURL: "https://example.com"}, nil)
+	extractor.EXPECT().
+		Extract("https://example.com", []byte("<html></html>")).
+		Return(parser.Result{Amount: parser.Decimal{Value: 10}}, true)
+	writer.EXPECT().
+		WriteMessages(mock.Anything, mock.Anything).
+		Return(assertError("broker down"))
+	expectParseFailed(t, failedWriter, events.FailurePublish, 0)
+
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter)
+
+	err := processor.Handle(context.Background(), &events.ParseRequested{
+		URL: "https://example.com",
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "kafka write:")
+}
+
+func TestHandle_ParseFailedWriteError(t *testing.T) {
+	t.Parallel()
+
+	extractor := processorMocks.NewMockExtractor(t)
+	fetcher := processorMocks.NewMockFetcher(t)
+	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
+
+	fetcher.EXPECT().
+		Fetch(mock.Anything, "https://example.com").
+		Return(nil, assertError("boom"))
+	failedWriter.EXPECT().
+		WriteMessages(mock.Anything, mock.Anything).
+		Return(assertError("broker down"))
+
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter)
+
+	err := processor.Handle(context.Background(), &events.ParseRequested{
+		URL: "https://example.com",
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "fetch:")
+	require.Contains(t, err.Error(), "parse_failed write:")
+
+	var failure *parse_requested_processor.Failure
+	require.ErrorAs(t, err, &failure)
+	require.False(t, failure.Published())
+}
+
+func TestHandle_Expired(t *testing.T) {
+	t.Parallel()
+
+	extractor := processorMocks.NewMockExtractor(t)
+	fetcher := processorMocks.NewMockFetcher(t)
+	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
+
+	expectParseFailed(t, failedWriter, events.FailureExpired, 0)
+
+	processor := parse_requested_processor.New(parse_requested_processor.Config{MaxStaleness: time.Hour}, extractor, fetcher, writer, failedWriter)
+
+	err := processor.Handle(context.Background(), &events.ParseRequested{
+		URL:         "https://example.com",
+		OccurredAt:  time.Now().Add(-3 * time.Hour),
+		ScheduledAt: time.Now().Add(-2 * time.Hour),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "request expired")
+
+	fetcher.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
+}
+
+func TestHandle_DuplicateSkipped(t *testing.T) {
+	t.Parallel()
+
+	extractor := processorMocks.NewMockExtractor(t)
+	fetcher := processorMocks.NewMockFetcher(t)
+	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
+	dedupe := processorMocks.NewMockDedupeStore(t)
+
+	dedupe.EXPECT().
+		Seen(mock.Anything, "ParseRequested|evt-1").
+		Return(true, nil)
+
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter,
+		parse_requested_processor.WithDedupe(dedupe))
+
+	require.NoError(t, processor.Handle(context.Background(), &events.ParseRequested{
+		EventID: "evt-1",
+		URL:     "https://example.com",
+	}))
+
+	fetcher.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
+	writer.AssertNotCalled(t, "WriteMessages", mock.Anything, mock.Anything)
+}
+
+func TestHandle_ForceBypassesDedupe(t *testing.T) {
+	t.Parallel()
+
+	extractor := processorMocks.NewMockExtractor(t)
+	fetcher := processorMocks.NewMockFetcher(t)
+	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
+	dedupe := processorMocks.NewMockDedupeStore(t)
+
+	fetcher.EXPECT().
+		Fetch(mock.Anything, "https://example.com").
+		Return(&parser.FetchResult{Body: []byte("<html></html>"), FinalURL: "https://example.com"}, nil)
+	extractor.EXPECT().
+		Extract("https://example.com", []byte("<html></html>")).
+		Return(parser.Result{Amount: parser.Decimal{Value: 10}, Currency: "RUB"}, true)
+	writer.EXPECT().
+		WriteMessages(mock.Anything, mock.Anything).
+		Return(nil)
+	dedupe.EXPECT().
+		Mark(mock.Anything, "ParseRequested|evt-1").
+		Return(nil)
+
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter,
+		parse_requested_processor.WithDedupe(dedupe))
+
+	require.NoError(t, processor.Handle(context.Background(), &events.ParseRequested{
+		EventID: "evt-1",
+		URL:     "https://example.com",
+		Force:   true,
+	}))
+
+	dedupe.AssertNotCalled(t, "Seen", mock.Anything, mock.Anything)
+}
+
+func TestHandle_PublishedFailureMarkedDone(t *testing.T) {
+	t.Parallel()
+
+	extractor := processorMocks.NewMockExtractor(t)
+	fetcher := processorMocks.NewMockFetcher(t)
+	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
+	dedupe := processorMocks.NewMockDedupeStore(t)
+
+	dedupe.EXPECT().
+		Seen(mock.Anything, "ParseRequested|evt-1").
+		Return(false, nil)
+	fetcher.EXPECT().
+		Fetch(mock.Anything, "https://example.com").
+		Return(nil, assertError("boom"))
+	expectParseFailed(t, failedWriter, events.FailureNetwork, 0)
+	dedupe.EXPECT().
+		Mark(mock.Anything, "ParseRequested|evt-1").
+		Return(nil).
+		Once()
+
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter,
+		parse_requested_processor.WithDedupe(dedupe))
+
+	require.Error(t, processor.Handle(context.Background(), &events.ParseRequested{
+		EventID: "evt-1",
+		URL:     "https://example.com",
+	}))
+}
+
+func TestHandle_UnpublishedFailureNotMarkedDone(t *testing.T) {
+	t.Parallel()
+
+	extractor := processorMocks.NewMockExtractor(t)
+	fetcher := processorMocks.NewMockFetcher(t)
+	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
+	dedupe := processorMocks.NewMockDedupeStore(t)
+
+	dedupe.EXPECT().
+		Seen(mock.Anything, "ParseRequested|evt-1").
+		Return(false, nil)
+	fetcher.EXPECT().
+		Fetch(mock.Anything, "https://example.com").
+		Return(nil, assertError("boom"))
+	failedWriter.EXPECT().
+		WriteMessages(mock.Anything, mock.Anything).
+		Return(assertError("kafka down"))
+
+	processor := parse_requested_processor.New(parse_requested_processor.Config{}, extractor, fetcher, writer, failedWriter,
+		parse_requested_processor.WithDedupe(dedupe))
+
+	err := processor.Handle(context.Background(), &events.ParseRequested{
+		EventID: "evt-1",
+		URL:     "https://example.com",
+	})
+	require.Error(t, err)
+
+	var f *parse_requested_processor.Failure
+	require.ErrorAs(t, err, &f)
+	require.False(t, f.Published())
+	dedupe.AssertNotCalled(t, "Mark", mock.Anything, mock.Anything)
+}
+
+func TestHandle_UnchangedPriceSuppressed(t *testing.T) {
+	t.Parallel()
+
+	extractor := processorMocks.NewMockExtractor(t)
+	fetcher := processorMocks.NewMockFetcher(t)
+	writer := kafkaMocks.NewMockWriter(t)
+	failedWriter := kafkaMocks.NewMockWriter(t)
+	state := processorMocks.NewMockStateStore(t)
+
+	fetcher.EXPECT().
+		Fetch(mock.Anything, "https://example.com").
+		Return(&parser.FetchResult{Body: []byte("<html></html>"), FinalURL: "https://example.com"}, nil)
+	extractor.EXPECT().
+		Extract("https://example.com", []byte("<html></html>")).
+		Return(parser.Result{Amount: parser.Decimal{Value: 10}, Currency: "RUB"}, true)
+	state.EXPECT().
+		LastPublished(mock.Anything, "product-1").
+		Return(models.Sha256Hex("https://example.com|10|RUB"), time.Now().Add(-time.Minute), nil)
+
+	processor := parse_requested_processor.New(parse_requested_processor.Config{Heartbeat: time.Hour}, extractor, fetcher, writer, failedWriter,
+		parse_requested_processor.WithStateStore(state))
+
+	require.NoError(t, processor.Handle(context.Background(), &events.ParseRequested{
+		ProductID: "product-1",
+		URL:       "https://example.com",
+	}))
+	require.Equal(t, uint64(1), processor.Suppressed())
+
+	writer.AssertNotCalled(t, "WriteMessages", mock.Anything, mock.Anything)
+}
+
+func TestHandle_ChangedOrHeartbeatPublished(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name            string
+		lastHash        string
+		lastPublishedAt time.Time
+	}{
+		{"changed", models.Sha256Hex("https://example.com|9|RUB"), time.Now().Add(-time.Minute)},
+		{"heartbeat", models.Sha256Hex("https://example.com|10|RUB"), time.Now().Add(-2 * time.Hour)},
+		{"first", "", time.Time{}},
+	}
+	for _, tc := range cases {
+		extractor := processorMocks.NewMockExtractor(t)
+		fetcher := processorMocks.NewMockFetcher(t)
+		writer := kafkaMocks.NewMockWriter(t)
+		failedWriter := kafkaMocks.NewMockWriter(t)
+		state := processorMocks.NewMockStateStore(t)
+
+		fetcher.EXPECT().
+			Fetch(mock.Anything, "https://example.com").
+			Return(&parser.FetchResult{Body: []byte("<html></html>"), FinalURL: "https://example.com"}, nil)
+		extractor.EXPECT().
+			Extract("https://example.com", []byte("<html></html>")).
+			Return(parser.Result{Amount: parser.Decimal{Value: 10}, Currency: "RUB"}, true)
+		state.EXPECT().
+			LastPublished(mock.Anything, "product-1").
+			Return(tc.lastHash, tc.lastPublishedAt, nil)
+		writer.EXPECT().
+			WriteMessages(mock.Anything, mock.Anything).
+			Return(nil)
+		state.EXPECT().
+			SavePublished(mock.Anything, "product-1", models.Sha256Hex("https://example.com|10|RUB"), mock.Anything).
+			Return(nil)
+
+		processor := parse_requested_processor.New(parse_requested_processor.Config{Heartbeat: time.Hour}, extractor, fetcher, writer, failedWriter,
+			parse_requested_processor.WithStateStore(state))
+
+		require.NoError(t, processor.Handle(context.Background(), &events.ParseRequested{
+			ProductID: "product-1",
+			URL:       "https://example.com",
+		}), tc.name)
+		require.Equal(t, uint64(0), processor.Suppressed(), tc.name)
+	}
+}
+
+func expectParseFailed(t *testing.T, w *kafkaMocks.MockWriter, category events.FailureCategory, status int) {
+	t.Helper()
+
+	w.EXPECT().
+		WriteMessages(mock.Anything, mock.Anything).
+		Run(func(_ context.Context, msgs ...kafka.Message) {
+			require.Len(t, msgs, 1)
+
+			var pf events.ParseFailed
+			require.NoError(t, json.Unmarshal(msgs[0].Value, &pf))
+			require.NotEmpty(t, pf.RequestEventID)
+			require.Equal(t, models.Sha256Hex("ParseFailed|"+pf.RequestEventID), pf.EventID)
+			require.Equal(t, category, pf.Category)
+			require.Equal(t, status, pf.HTTPStatus)
+			require.NotEmpty(t, pf.Reason)
+		}).
+		Return(nil).
+		Once()
+}
+
 type assertError string
 
 func (e assertError) Error() string {
@@ -0,0 +1,47 @@
+// Package swagger serves the OpenAPI document of the service and a Swagger UI
+// page for it. The UI assets, swagger-ui-dist of the version in ui/VERSION,
+// are committed in ui/ and embedded, so the page works without access to a
+// CDN. scripts/swagger-ui.sh replaces them when the version changes.
+package swagger
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+//go:embed openapi.yaml
+var spec []byte
+
+//go:embed index.html
+var index []byte
+
+//go:embed ui
+var assets embed.FS
+
+// Handler serves the UI and its assets at path and the document at
+// path/openapi.yaml. The UI loads both by relative URLs, so path without a
+// trailing slash is redirected.
+func Handler(path string) http.Handler {
+	base := "/" + strings.Trim(path, "/")
+	mux := http.NewServeMux()
+	mux.HandleFunc(base, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, base+"/", http.StatusMovedPermanently)
+	})
+	ui, _ := fs.Sub(assets, "ui")
+	files := http.StripPrefix(base+"/", http.FileServerFS(ui))
+	mux.HandleFunc(base+"/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != base+"/" {
+			files.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(index)
+	})
+	mux.HandleFunc(base+"/openapi.yaml", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(spec)
+	})
+	return mux
+}
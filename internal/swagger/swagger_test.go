@@ -0,0 +1,69 @@
+package swagger_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/LehaAlexey/Parsing/internal/swagger"
+	"github.com/stretchr/testify/require"
+	"go.yaml.in/yaml/v4"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	h := swagger.Handler("/docs")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/openapi.yaml", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var doc struct {
+		OpenAPI    string         `yaml:"openapi"`
+		Paths      map[string]any `yaml:"paths"`
+		Components struct {
+			Schemas map[string]any `yaml:"schemas"`
+		} `yaml:"components"`
+	}
+	require.NoError(t, yaml.Unmarshal(rec.Body.Bytes(), &doc))
+	require.Equal(t, "3.0.3", doc.OpenAPI)
+	for _, p := range []string{"/health", "/livez", "/readyz", "/metrics", "/v1/parse", "/admin/domains", "/admin/breakers"} {
+		require.Contains(t, doc.Paths, p)
+	}
+	for _, s := range []string{"ParseRequested", "PriceMeasured", "ParseFailed"} {
+		require.Contains(t, doc.Components.Schemas, s)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "SwaggerUIBundle")
+	// the assets are embedded, not loaded from a CDN
+	require.NotContains(t, rec.Body.String(), "https://")
+	require.Contains(t, rec.Body.String(), `src="swagger-ui-bundle.js"`)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/VERSION", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "5.18.2\n", rec.Body.String())
+
+	for asset, contentType := range map[string]string{
+		"/docs/swagger-ui-bundle.js": "javascript",
+		"/docs/swagger-ui.css":       "text/css",
+	} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, asset, nil))
+		require.Equal(t, http.StatusOK, rec.Code, asset)
+		require.Contains(t, rec.Header().Get("Content-Type"), contentType, asset)
+		require.NotEmpty(t, rec.Body.Bytes(), asset)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/missing.js", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	require.Equal(t, http.StatusMovedPermanently, rec.Code)
+}
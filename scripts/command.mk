@@ -14,3 +14,6 @@ test:
 build:
 	go build ./...
 
+.PHONY: swagger-ui
+swagger-ui:
+	sh ./scripts/swagger-ui.sh
@@ -0,0 +1,20 @@
+#!/bin/sh
+# Replaces the Swagger UI assets committed in internal/swagger/ui with those of
+# the swagger-ui-dist version in internal/swagger/ui/VERSION. Run it after
+# changing the version and commit the result.
+set -eu
+
+dir="$(dirname "$0")/../internal/swagger/ui"
+version="$(cat "$dir/VERSION")"
+
+url="https://registry.npmjs.org/swagger-ui-dist/-/swagger-ui-dist-$version.tgz"
+tmp="$(mktemp -d)"
+trap 'rm -rf "$tmp"' EXIT
+
+if command -v curl >/dev/null 2>&1; then
+	curl -fsSL -o "$tmp/dist.tgz" "$url"
+else
+	wget -q -O "$tmp/dist.tgz" "$url"
+fi
+tar -xzf "$tmp/dist.tgz" -C "$tmp" package/swagger-ui-bundle.js package/swagger-ui.css package/LICENSE
+cp "$tmp/package/swagger-ui-bundle.js" "$tmp/package/swagger-ui.css" "$tmp/package/LICENSE" "$dir/"